@@ -0,0 +1,465 @@
+package httpc
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BalanceRoundRobin       = "roundRobin"
+	BalanceRandom           = "random"
+	BalanceWeighted         = "weighted"
+	BalanceLeastOutstanding = "leastOutstanding"
+
+	StatBalanceEjected   = "balanceEjected"
+	StatHealthCheckFails = "healthCheckFails"
+)
+
+type endpoint struct {
+	url    *url.URL
+	weight int
+	// priority is the SRV priority, lower ones are preferred, 0 for configured endpoints
+	priority    int
+	outstanding atomic.Int64
+
+	mu           sync.Mutex
+	fails        int
+	ejectedUntil time.Time
+	unhealthy    bool
+	// current is the running weight of the smooth weighted round-robin
+	current int
+}
+
+func (e *endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.unhealthy && !now.Before(e.ejectedUntil)
+}
+
+func parseEndpoint(raw string, weight int) (*endpoint, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrInvalidEndpoint, raw)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf(ErrInvalidEndpoint, raw)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return &endpoint{url: u, weight: weight}, nil
+}
+
+// balancer rewrites requests to Host into requests to one of its endpoints.
+type balancer struct {
+	h      *HttpC
+	config *BalancerConfig
+	next   http.RoundTripper
+
+	mu        sync.RWMutex
+	static    []*endpoint
+	endpoints []*endpoint
+	rr        atomic.Uint64
+
+	// weightMu serializes the smooth weighted round-robin
+	weightMu sync.Mutex
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func newBalancer(h *HttpC, c *BalancerConfig, next http.RoundTripper) (*balancer, error) {
+	switch c.Balance {
+	case "", BalanceRoundRobin, BalanceRandom, BalanceWeighted, BalanceLeastOutstanding:
+	default:
+		return nil, errors.Errorf(ErrUnknownBalance, c.Balance)
+	}
+
+	b := &balancer{
+		h:      h,
+		config: c,
+		next:   next,
+		stop:   make(chan struct{}),
+	}
+	for _, ec := range c.Endpoints {
+		e, err := parseEndpoint(ec.URL, ec.Weight)
+		if err != nil {
+			return nil, err
+		}
+		b.static = append(b.static, e)
+	}
+
+	fromFile, err := b.loadFile()
+	if err != nil {
+		return nil, err
+	}
+	b.set(append(append([]*endpoint(nil), b.static...), fromFile...))
+	return b, nil
+}
+
+// open resolves SRV and starts the refresh and health check loops.
+func (b *balancer) open() error {
+	if b.config.SRV != "" || b.config.EndpointsFile != "" {
+		if err := b.refresh(); err != nil {
+			return err
+		}
+		if interval := b.config.RefreshInterval.ToDuration(); interval > 0 {
+			go b.loop(interval, b.refreshLog)
+		}
+	}
+
+	if hc := b.config.HealthCheck; hc != nil && hc.Interval > 0 {
+		b.checkAll()
+		go b.loop(hc.Interval.ToDuration(), b.checkAll)
+	}
+	return nil
+}
+
+func (b *balancer) close() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+func (b *balancer) loop(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *balancer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b.config.Host != "" && req.URL.Host != b.config.Host {
+		return b.next.RoundTrip(req)
+	}
+
+	e := b.pick()
+	if e == nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, errors.Errorf(ErrNoEndpoint, req.URL.Host)
+	}
+
+	r := req.Clone(req.Context())
+	if b.config.PreserveHost {
+		if r.Host == "" {
+			r.Host = req.URL.Host
+		}
+	} else if r.Host == req.URL.Host {
+		r.Host = ""
+	}
+	r.URL.Scheme = e.url.Scheme
+	r.URL.Host = e.url.Host
+	if prefix := strings.TrimSuffix(e.url.Path, "/"); prefix != "" {
+		r.URL.Path = prefix + r.URL.Path
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = prefix + r.URL.RawPath
+		}
+	}
+
+	e.outstanding.Inc()
+	resp, err := b.next.RoundTrip(r)
+	if err != nil {
+		e.outstanding.Dec()
+		if !errors.Is(err, context.Canceled) && req.Context().Err() == nil {
+			b.fail(e)
+		}
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		b.fail(e)
+	default:
+		b.succeed(e)
+	}
+	resp.Body = &doneBody{ReadCloser: resp.Body, done: func() { e.outstanding.Dec() }}
+	return resp, nil
+}
+
+func (b *balancer) pick() *endpoint {
+	b.mu.RLock()
+	all := b.endpoints
+	b.mu.RUnlock()
+	if len(all) == 0 {
+		return nil
+	}
+
+	// only the available endpoints of the lowest priority are candidates
+	now := time.Now()
+	candidates := make([]*endpoint, 0, len(all))
+	for _, e := range all {
+		if !e.available(now) {
+			continue
+		}
+		if len(candidates) > 0 && e.priority < candidates[0].priority {
+			candidates = candidates[:0]
+		}
+		if len(candidates) == 0 || e.priority == candidates[0].priority {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = all
+	}
+
+	switch b.config.Balance {
+	case BalanceRandom:
+		return candidates[rand.Intn(len(candidates))]
+	case BalanceWeighted:
+		return b.pickWeighted(candidates)
+	case BalanceLeastOutstanding:
+		best := candidates[0]
+		for _, e := range candidates[1:] {
+			if e.outstanding.Load() < best.outstanding.Load() {
+				best = e
+			}
+		}
+		return best
+	default:
+		return candidates[(b.rr.Inc()-1)%uint64(len(candidates))]
+	}
+}
+
+// pickWeighted is the smooth weighted round-robin of nginx, which interleaves heavy endpoints with light ones.
+func (b *balancer) pickWeighted(candidates []*endpoint) *endpoint {
+	b.weightMu.Lock()
+	defer b.weightMu.Unlock()
+
+	var best *endpoint
+	total := 0
+	for _, e := range candidates {
+		e.current += e.weight
+		total += e.weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (b *balancer) fail(e *endpoint) {
+	if b.config.MaxFails <= 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.fails++
+	if e.fails >= b.config.MaxFails {
+		e.fails = 0
+		e.ejectedUntil = time.Now().Add(b.config.EjectDuration.ToDuration())
+		b.h.stats.Incr(StatBalanceEjected, 1)
+		b.h.Warn("Endpoint ejected", zap.String("endpoint", e.url.String()))
+	}
+}
+
+func (b *balancer) succeed(e *endpoint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fails = 0
+}
+
+// set replaces the endpoints, keeping the state of those whose url did not change.
+func (b *balancer) set(endpoints []*endpoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.weightMu.Lock()
+	defer b.weightMu.Unlock()
+
+	old := make(map[string]*endpoint, len(b.endpoints))
+	for _, e := range b.endpoints {
+		old[e.url.String()] = e
+	}
+
+	merged := make([]*endpoint, 0, len(endpoints))
+	seen := make(map[string]struct{}, len(endpoints))
+	for _, e := range endpoints {
+		key := e.url.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if prev, ok := old[key]; ok {
+			prev.weight = e.weight
+			prev.priority = e.priority
+			e = prev
+		}
+		merged = append(merged, e)
+	}
+	b.endpoints = merged
+}
+
+func (b *balancer) snapshot() []*endpoint {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.endpoints
+}
+
+func (b *balancer) refresh() error {
+	endpoints := append([]*endpoint(nil), b.static...)
+
+	fromFile, err := b.loadFile()
+	if err != nil {
+		return err
+	}
+	endpoints = append(endpoints, fromFile...)
+
+	fromSRV, err := b.lookupSRV()
+	if err != nil {
+		return err
+	}
+	endpoints = append(endpoints, fromSRV...)
+
+	b.set(endpoints)
+	return nil
+}
+
+// refreshLog keeps the current endpoints when refresh fails.
+func (b *balancer) refreshLog() {
+	if err := b.refresh(); err != nil {
+		b.h.Error("Refresh endpoints fail", zap.Error(err))
+		b.h.AppendError(err)
+	}
+}
+
+func (b *balancer) loadFile() ([]*endpoint, error) {
+	if b.config.EndpointsFile == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(b.config.EndpointsFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrLoadEndpoints, b.config.EndpointsFile)
+	}
+	defer f.Close()
+
+	var endpoints []*endpoint
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		weight := 1
+		if len(fields) > 1 {
+			weight, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, ErrInvalidEndpoint, line)
+			}
+		}
+		e, err := parseEndpoint(fields[0], weight)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, ErrLoadEndpoints, b.config.EndpointsFile)
+	}
+	return endpoints, nil
+}
+
+func (b *balancer) lookupSRV() ([]*endpoint, error) {
+	if b.config.SRV == "" {
+		return nil, nil
+	}
+
+	_, records, err := net.LookupSRV("", "", b.config.SRV)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrLookupSRV, b.config.SRV)
+	}
+
+	scheme := b.config.SRVScheme
+	if scheme == "" {
+		scheme = DefaultSRVScheme
+	}
+	endpoints := make([]*endpoint, 0, len(records))
+	for _, r := range records {
+		host := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+		e, err := parseEndpoint(scheme+"://"+host, int(r.Weight))
+		if err != nil {
+			return nil, err
+		}
+		e.priority = int(r.Priority)
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func (b *balancer) checkAll() {
+	wg := sync.WaitGroup{}
+	for _, e := range b.snapshot() {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			b.check(e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// check requests the health check path through the base transport, skipping the other layers.
+func (b *balancer) check(e *endpoint) {
+	hc := b.config.HealthCheck
+	timeout := hc.Timeout.ToDuration()
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout.ToDuration()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	u := *e.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(hc.Path, "/")
+
+	healthy := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = b.h.base.RoundTrip(req)
+		if err == nil {
+			_, _ = ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			healthy = resp.StatusCode >= 200 && resp.StatusCode < 300
+		}
+	}
+	if !healthy {
+		b.h.stats.Incr(StatHealthCheckFails, 1)
+	}
+
+	e.mu.Lock()
+	changed := e.unhealthy == healthy
+	e.unhealthy = !healthy
+	e.mu.Unlock()
+
+	if changed {
+		b.h.Info("Endpoint health changed",
+			zap.String("endpoint", e.url.String()),
+			zap.Bool("healthy", healthy),
+			zap.Error(err))
+	}
+}
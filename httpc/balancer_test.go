@@ -0,0 +1,172 @@
+package httpc
+
+import (
+	"github.com/donkeywon/gtil/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newNamedServer(name string, healthy *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(name + " " + r.URL.Path))
+	}))
+}
+
+func getBody(t *testing.T, h *HttpC, u string) string {
+	resp, err := h.Get(u)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	return string(body)
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	a := newNamedServer("a", atomic.NewBool(true))
+	defer a.Close()
+	b := newNamedServer("b", atomic.NewBool(true))
+	defer b.Close()
+
+	c := NewConfig()
+	c.Balancer = NewBalancerConfig("users", a.URL, b.URL+"/base/")
+	h := mustNew(t, c)
+
+	assert.Equal(t, "a /x", getBody(t, h, "http://users/x"))
+	assert.Equal(t, "b /base/x", getBody(t, h, "http://users/x"))
+	assert.Equal(t, "a /x", getBody(t, h, "http://users/x"))
+
+	b.Close()
+	for i := 0; i < DefaultBalanceMaxFails*2; i++ {
+		_, _ = h.Get("http://users/x")
+	}
+	assert.Equal(t, float64(1), h.Statistics()[StatBalanceEjected])
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "a /x", getBody(t, h, "http://users/x"), "ejected endpoint should be skipped")
+	}
+
+	assert.Equal(t, "a /other", getBody(t, h, a.URL+"/other"), "other hosts are not balanced")
+}
+
+func TestBalancerWeighted(t *testing.T) {
+	a := newNamedServer("a", atomic.NewBool(true))
+	defer a.Close()
+	b := newNamedServer("b", atomic.NewBool(true))
+	defer b.Close()
+
+	c := NewConfig()
+	c.Balancer = NewBalancerConfig("")
+	c.Balancer.Balance = BalanceWeighted
+	c.Balancer.Endpoints = []*EndpointConfig{{URL: a.URL, Weight: 3}, {URL: b.URL, Weight: 1}}
+	h := mustNew(t, c)
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[getBody(t, h, "http://any/")]++
+	}
+	assert.Equal(t, map[string]int{"a /": 6, "b /": 2}, counts)
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	a := newNamedServer("a", atomic.NewBool(true))
+	defer a.Close()
+	b := newNamedServer("b", atomic.NewBool(true))
+	defer b.Close()
+
+	c := NewConfig()
+	c.Balancer = NewBalancerConfig("svc", a.URL, b.URL)
+	c.Balancer.Balance = BalanceLeastOutstanding
+	h := mustNew(t, c)
+
+	held, err := h.Get("http://svc/")
+	assert.NoError(t, err)
+	first, _ := ioutil.ReadAll(held.Body)
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, string(first), getBody(t, h, "http://svc/"), "endpoint with an open response should be avoided")
+	}
+	_ = held.Body.Close()
+
+	c.Balancer.Balance = "unknown"
+	_, err = New(c)
+	assert.Error(t, err)
+}
+
+func TestBalancerHealthCheckAndFile(t *testing.T) {
+	aHealthy, bHealthy := atomic.NewBool(true), atomic.NewBool(false)
+	a := newNamedServer("a", aHealthy)
+	defer a.Close()
+	b := newNamedServer("b", bHealthy)
+	defer b.Close()
+
+	file := filepath.Join(t.TempDir(), "endpoints")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("# endpoints\n"+a.URL+"\n\n"+b.URL+" 2\n"), 0600))
+
+	c := NewConfig()
+	c.Balancer = NewBalancerConfig("svc")
+	c.Balancer.EndpointsFile = file
+	c.Balancer.RefreshInterval = config.Duration(20 * time.Millisecond)
+	c.Balancer.HealthCheck = NewHealthCheckConfig("/health")
+	c.Balancer.HealthCheck.Interval = config.Duration(20 * time.Millisecond)
+	c.Balancer.HealthCheck.Timeout = 0
+	h := mustNew(t, c)
+	assert.NoError(t, h.Open())
+	defer h.Close()
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "a /", getBody(t, h, "http://svc/"), "unhealthy endpoint should be skipped")
+	}
+	assert.True(t, h.Statistics()[StatHealthCheckFails] > 0)
+
+	bHealthy.Store(true)
+	assert.NoError(t, ioutil.WriteFile(file, []byte(b.URL+"\n"), 0600))
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "b /", getBody(t, h, "http://svc/"))
+	}
+
+	c.Balancer.EndpointsFile = filepath.Join(t.TempDir(), "missing")
+	_, err := New(c)
+	assert.Error(t, err)
+}
+
+func TestBalancerPreserveHost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Balancer = NewBalancerConfig("users", srv.URL)
+	h := mustNew(t, c)
+	assert.Equal(t, srv.Listener.Addr().String(), getBody(t, h, "http://users/"))
+
+	c.Balancer.PreserveHost = true
+	h = mustNew(t, c)
+	assert.Equal(t, "users", getBody(t, h, "http://users/"))
+}
+
+func TestBalancerPriority(t *testing.T) {
+	b := &balancer{config: NewBalancerConfig("svc")}
+	primary, _ := parseEndpoint("http://primary", 1)
+	primary.priority = 10
+	backup, _ := parseEndpoint("http://backup", 1)
+	backup.priority = 20
+	b.set([]*endpoint{backup, primary})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, primary, b.pick(), "lowest priority should be picked")
+	}
+
+	primary.unhealthy = true
+	assert.Equal(t, backup, b.pick(), "next priority should be used when the lowest is unavailable")
+}
@@ -0,0 +1,250 @@
+package httpc
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatBreakerOpened   = "breakerOpened"
+	StatBreakerRejected = "breakerRejected"
+
+	// statBreakerStatePrefix is followed by the host, the value is a BreakerState
+	statBreakerStatePrefix = "breakerState."
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitOpenError is returned without sending the request while the breaker of Host is open.
+type CircuitOpenError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "circuit breaker open for host " + e.Host
+}
+
+// breakerResult is what a response says about the health of the upstream.
+type breakerResult int
+
+const (
+	resultSuccess breakerResult = iota
+	resultFailure
+	// resultNeutral says nothing, e.g. a request canceled by its caller
+	resultNeutral
+)
+
+type breaker struct {
+	mu sync.Mutex
+
+	state BreakerState
+	// generation changes with state, results of requests admitted in an older generation are ignored
+	generation  uint64
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int
+	successes   int
+}
+
+type breakerTransport struct {
+	h      *HttpC
+	config *BreakerConfig
+	next   http.RoundTripper
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func newBreakerTransport(h *HttpC, c *BreakerConfig, next http.RoundTripper) *breakerTransport {
+	return &breakerTransport{
+		h:        h,
+		config:   c,
+		next:     next,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	b := t.get(host)
+
+	gen, err := t.allow(host, b)
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		t.h.stats.Incr(StatBreakerRejected, 1)
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	t.report(host, b, gen, resultOf(req, resp, err))
+	return resp, err
+}
+
+func (t *breakerTransport) get(host string) *breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{windowStart: time.Now()}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+// allow admits a request and returns the generation it is admitted in.
+func (t *breakerTransport) allow(host string, b *breaker) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		wait := t.config.CoolDown.ToDuration() - time.Since(b.openedAt)
+		if wait > 0 {
+			return 0, &CircuitOpenError{Host: host, RetryAfter: wait}
+		}
+		t.transit(host, b, BreakerHalfOpen)
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.probes >= t.halfOpenProbes() {
+			return 0, &CircuitOpenError{Host: host}
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+func (t *breakerTransport) report(host string, b *breaker, gen uint64, result breakerResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gen != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		switch result {
+		case resultNeutral:
+			// free the probe slot for another request
+			b.probes--
+			return
+		case resultFailure:
+			t.transit(host, b, BreakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= t.halfOpenProbes() {
+			t.transit(host, b, BreakerClosed)
+		}
+	case BreakerClosed:
+		now := time.Now()
+		if window := t.config.Window.ToDuration(); window > 0 && now.Sub(b.windowStart) > window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+
+		if result == resultNeutral {
+			return
+		}
+		b.requests++
+		if result == resultSuccess {
+			b.consecutive = 0
+			return
+		}
+		b.failures++
+		b.consecutive++
+
+		if t.config.ConsecutiveFailures > 0 && b.consecutive >= t.config.ConsecutiveFailures ||
+			t.config.FailureRatio > 0 && b.requests >= t.config.MinRequests &&
+				float64(b.failures)/float64(b.requests) >= t.config.FailureRatio {
+			t.transit(host, b, BreakerOpen)
+		}
+	}
+}
+
+// transit must be called with b.mu held.
+func (t *breakerTransport) transit(host string, b *breaker, to BreakerState) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.probes = 0
+	b.successes = 0
+
+	switch to {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+		t.h.stats.Incr(StatBreakerOpened, 1)
+	case BreakerClosed:
+		b.windowStart = time.Now()
+		b.requests = 0
+		b.failures = 0
+		b.consecutive = 0
+	}
+
+	t.h.Info("Circuit breaker state changed",
+		zap.String("host", host),
+		zap.Stringer("from", from),
+		zap.Stringer("to", to))
+}
+
+func (t *breakerTransport) halfOpenProbes() int {
+	if t.config.HalfOpenProbes <= 0 {
+		return 1
+	}
+	return t.config.HalfOpenProbes
+}
+
+func (t *breakerTransport) export(m map[string]float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for host, b := range t.breakers {
+		b.mu.Lock()
+		m[statBreakerStatePrefix+host] = float64(b.state)
+		b.mu.Unlock()
+	}
+}
+
+// resultOf reports whether the upstream is considered healthy by the response,
+// a request canceled by its caller says nothing about the upstream.
+func resultOf(req *http.Request, resp *http.Response, err error) breakerResult {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || req.Context().Err() != nil {
+			return resultNeutral
+		}
+		return resultFailure
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resultFailure
+	}
+	return resultSuccess
+}
@@ -0,0 +1,116 @@
+package httpc
+
+import (
+	"github.com/donkeywon/gtil/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	healthy := atomic.NewBool(false)
+	hits := atomic.NewInt64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Inc()
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Breaker = NewBreakerConfig()
+	c.Breaker.ConsecutiveFailures = 3
+	c.Breaker.CoolDown = config.Duration(50 * time.Millisecond)
+	h := mustNew(t, c)
+
+	for i := 0; i < 3; i++ {
+		resp, err := h.Get(srv.URL)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	_, err := h.Get(srv.URL)
+	var openErr *CircuitOpenError
+	assert.True(t, errors.As(err, &openErr))
+	assert.Equal(t, int64(3), hits.Load())
+	assert.Equal(t, float64(BreakerOpen), h.Statistics()[statBreakerStatePrefix+openErr.Host])
+	assert.Equal(t, float64(1), h.Statistics()[StatBreakerRejected])
+
+	time.Sleep(60 * time.Millisecond)
+	healthy.Store(true)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, float64(BreakerClosed), h.Statistics()[statBreakerStatePrefix+openErr.Host])
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Breaker = NewBreakerConfig()
+	c.Breaker.ConsecutiveFailures = 0
+	c.Breaker.MinRequests = 4
+	c.Breaker.CoolDown = config.Duration(20 * time.Millisecond)
+	h := mustNew(t, c)
+
+	for i := 0; i < 4; i++ {
+		resp, err := h.Get(srv.URL)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	_, err := h.Get(srv.URL)
+	assert.Error(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	_, err = h.Get(srv.URL)
+	var openErr *CircuitOpenError
+	assert.True(t, errors.As(err, &openErr))
+	assert.Equal(t, float64(2), h.Statistics()[StatBreakerOpened])
+}
+
+func TestBreakerStaleAndNeutralResults(t *testing.T) {
+	c := NewConfig()
+	c.Breaker = NewBreakerConfig()
+	c.Breaker.ConsecutiveFailures = 1
+	c.Breaker.HalfOpenProbes = 1
+	c.Breaker.CoolDown = config.Duration(10 * time.Millisecond)
+	h := mustNew(t, c)
+	bt := h.breaker
+	b := bt.get("x")
+
+	stale, err := bt.allow("x", b)
+	assert.NoError(t, err)
+	gen, err := bt.allow("x", b)
+	assert.NoError(t, err)
+	bt.report("x", b, gen, resultFailure)
+	assert.Equal(t, BreakerOpen, b.state)
+
+	time.Sleep(20 * time.Millisecond)
+	probe, err := bt.allow("x", b)
+	assert.NoError(t, err)
+	assert.Equal(t, BreakerHalfOpen, b.state)
+
+	bt.report("x", b, stale, resultFailure)
+	assert.Equal(t, BreakerHalfOpen, b.state, "result admitted before the state change should be ignored")
+
+	bt.report("x", b, probe, resultNeutral)
+	assert.Equal(t, BreakerHalfOpen, b.state, "canceled probe should be neutral")
+	probe, err = bt.allow("x", b)
+	assert.NoError(t, err, "canceled probe should release its slot")
+
+	bt.report("x", b, probe, resultSuccess)
+	assert.Equal(t, BreakerClosed, b.state)
+}
@@ -18,8 +18,67 @@ const (
 	DefaultMaxResponseHeaderBytes = 0
 	DefaultWriteBufferSize        = 4096
 	DefaultReadBufferSize         = 4096
+	DefaultShutdownTimeout        = config.Duration(time.Second * 10)
+	DefaultDialTimeout            = config.Duration(time.Second * 30)
+	DefaultKeepAlive              = config.Duration(time.Second * 30)
+	DefaultTLSHandshakeTimeout    = config.Duration(time.Second * 10)
+
+	DefaultRetryMaxAttempts       = 3
+	DefaultRetryInitialBackoff    = config.Duration(time.Millisecond * 100)
+	DefaultRetryMaxBackoff        = config.Duration(time.Second * 5)
+	DefaultRetryMultiplier        = 2.0
+	DefaultRetryJitter            = 0.2
+	DefaultRetryMaxRetryAfter     = config.Duration(time.Second * 30)
+	DefaultRetryMaxReplayBodySize = 1 << 20
+)
+
+const (
+	DefaultBalance                = BalanceRoundRobin
+	DefaultBalanceMaxFails        = 3
+	DefaultBalanceEjectDuration   = config.Duration(time.Second * 30)
+	DefaultBalanceRefreshInterval = config.Duration(time.Second * 30)
+	DefaultSRVScheme              = "http"
+	DefaultHealthCheckInterval    = config.Duration(time.Second * 10)
+	DefaultHealthCheckTimeout     = config.Duration(time.Second * 2)
+)
+
+const (
+	DefaultBreakerFailureRatio        = 0.5
+	DefaultBreakerMinRequests         = 20
+	DefaultBreakerWindow              = config.Duration(time.Second * 10)
+	DefaultBreakerConsecutiveFailures = 5
+	DefaultBreakerCoolDown            = config.Duration(time.Second * 30)
+	DefaultBreakerHalfOpenProbes      = 1
+)
+
+const (
+	DefaultLogMaxBodySize = 1024
+
+	DefaultHedgePercentile  = 0.95
+	DefaultHedgeMinSamples  = 20
+	DefaultHedgeMaxHedges   = 1
+	DefaultHedgeBudgetRatio = 0.1
+)
+
+var (
+	DefaultLatencyBuckets = []config.Duration{
+		config.Duration(time.Millisecond * 5),
+		config.Duration(time.Millisecond * 10),
+		config.Duration(time.Millisecond * 25),
+		config.Duration(time.Millisecond * 50),
+		config.Duration(time.Millisecond * 100),
+		config.Duration(time.Millisecond * 250),
+		config.Duration(time.Millisecond * 500),
+		config.Duration(time.Second),
+		config.Duration(time.Millisecond * 2500),
+		config.Duration(time.Second * 5),
+		config.Duration(time.Second * 10),
+	}
+	DefaultLogRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Signature"}
 )
 
+var DefaultRetryStatus = []int{429, 502, 503, 504}
+
 type Config struct {
 	Timeout                config.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
 	DisableKeepAlives      bool            `yaml:"disableKeepAlives,omitempty" json:"disableKeepAlives,omitempty"`
@@ -33,6 +92,97 @@ type Config struct {
 	MaxResponseHeaderBytes int64           `yaml:"maxResponseHeaderBytes,omitempty" json:"maxResponseHeaderBytes,omitempty"`
 	WriteBufferSize        int             `yaml:"writeBufferSize,omitempty" json:"writeBufferSize,omitempty"`
 	ReadBufferSize         int             `yaml:"readBufferSize,omitempty" json:"readBufferSize,omitempty"`
+	ShutdownTimeout        config.Duration `yaml:"shutdownTimeout,omitempty" json:"shutdownTimeout,omitempty"`
+	DialTimeout            config.Duration `yaml:"dialTimeout,omitempty" json:"dialTimeout,omitempty"`
+	KeepAlive              config.Duration `yaml:"keepAlive,omitempty" json:"keepAlive,omitempty"`
+	TLSHandshakeTimeout    config.Duration `yaml:"tlsHandshakeTimeout,omitempty" json:"tlsHandshakeTimeout,omitempty"`
+
+	// AttemptTimeout bounds each attempt made by retries and hedges, 0 means only Timeout applies
+	AttemptTimeout config.Duration `yaml:"attemptTimeout,omitempty" json:"attemptTimeout,omitempty"`
+
+	// BaseURL prefixes relative paths given to NewRequest
+	BaseURL string `yaml:"baseURL,omitempty" json:"baseURL,omitempty"`
+
+	// LocalAddr is the local ip or ip:port outgoing connections are bound to, empty means any
+	LocalAddr string `yaml:"localAddr,omitempty" json:"localAddr,omitempty"`
+
+	// LatencyBuckets are the upper bounds of the per host latency histograms
+	LatencyBuckets []config.Duration `yaml:"latencyBuckets,omitempty" json:"latencyBuckets,omitempty"`
+
+	Limits   []*LimitConfig  `yaml:"limits,omitempty" json:"limits,omitempty"`
+	TLS      *TLSConfig      `yaml:"tls,omitempty" json:"tls,omitempty"`
+	Proxy    *ProxyConfig    `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	Retry    *RetryConfig    `yaml:"retry,omitempty" json:"retry,omitempty"`
+	Hedge    *HedgeConfig    `yaml:"hedge,omitempty" json:"hedge,omitempty"`
+	Balancer *BalancerConfig `yaml:"balancer,omitempty" json:"balancer,omitempty"`
+	Breaker  *BreakerConfig  `yaml:"breaker,omitempty" json:"breaker,omitempty"`
+	Log      *LogConfig      `yaml:"log,omitempty" json:"log,omitempty"`
+}
+
+// LogConfig enables debug logging of requests and responses, nil means disabled.
+// Values of RedactHeaders are replaced and bodies are truncated to MaxBodySize, 0 means bodies are not logged.
+// A request body is only logged when it can be read again through GetBody.
+type LogConfig struct {
+	RedactHeaders []string `yaml:"redactHeaders,omitempty" json:"redactHeaders,omitempty"`
+	MaxBodySize   int      `yaml:"maxBodySize,omitempty" json:"maxBodySize,omitempty"`
+}
+
+// LimitConfig throttles requests sent to Host, every matching LimitConfig applies to each attempt.
+// Host is empty for one budget shared by all hosts, * for a separate budget per host, or a host
+// matched against host:port and host. A request waits for both a token and a MaxInFlight slot
+// as long as its context allows, a slot is held until the response body is closed.
+type LimitConfig struct {
+	Host string `yaml:"host,omitempty" json:"host,omitempty"`
+	// Rate is requests per second, 0 means unlimited.
+	Rate  float64 `yaml:"rate,omitempty" json:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty" json:"burst,omitempty"`
+	// MaxInFlight is the max concurrent requests, 0 means unlimited.
+	MaxInFlight int `yaml:"maxInFlight,omitempty" json:"maxInFlight,omitempty"`
+}
+
+func (c *LimitConfig) burst() int {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	if c.Rate < 1 {
+		return 1
+	}
+	return int(c.Rate)
+}
+
+// TLSConfig customizes TLS connections, nil means the system roots and no client certificate.
+// InsecureSkipVerify disables server certificate verification and is meant for tests only.
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+	CertFile           string `yaml:"certFile,omitempty" json:"certFile,omitempty"`
+	KeyFile            string `yaml:"keyFile,omitempty" json:"keyFile,omitempty"`
+	ServerName         string `yaml:"serverName,omitempty" json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty" json:"insecureSkipVerify,omitempty"`
+}
+
+// ProxyConfig routes requests through a proxy, nil means no proxy.
+// HTTPProxy and HTTPSProxy accept http, https and socks5 urls, NoProxy is a comma separated
+// list of hosts, domains and CIDRs to connect directly, the same as the NO_PROXY environment variable.
+// FromEnvironment uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY instead.
+type ProxyConfig struct {
+	HTTPProxy       string `yaml:"httpProxy,omitempty" json:"httpProxy,omitempty"`
+	HTTPSProxy      string `yaml:"httpsProxy,omitempty" json:"httpsProxy,omitempty"`
+	NoProxy         string `yaml:"noProxy,omitempty" json:"noProxy,omitempty"`
+	FromEnvironment bool   `yaml:"fromEnvironment,omitempty" json:"fromEnvironment,omitempty"`
+}
+
+// RetryConfig enables retrying failed requests, nil means disabled.
+// Non-idempotent requests are only retried when the connection could not be established.
+// Zero fields but Jitter use the Default* values, MaxAttempts 1 sends requests once.
+type RetryConfig struct {
+	MaxAttempts       int             `yaml:"maxAttempts,omitempty" json:"maxAttempts,omitempty"`
+	InitialBackoff    config.Duration `yaml:"initialBackoff,omitempty" json:"initialBackoff,omitempty"`
+	MaxBackoff        config.Duration `yaml:"maxBackoff,omitempty" json:"maxBackoff,omitempty"`
+	Multiplier        float64         `yaml:"multiplier,omitempty" json:"multiplier,omitempty"`
+	Jitter            float64         `yaml:"jitter,omitempty" json:"jitter,omitempty"`
+	Status            []int           `yaml:"status,omitempty" json:"status,omitempty"`
+	MaxRetryAfter     config.Duration `yaml:"maxRetryAfter,omitempty" json:"maxRetryAfter,omitempty"`
+	MaxReplayBodySize int64           `yaml:"maxReplayBodySize,omitempty" json:"maxReplayBodySize,omitempty"`
 }
 
 func NewConfig() *Config {
@@ -49,5 +199,142 @@ func NewConfig() *Config {
 		MaxResponseHeaderBytes: DefaultMaxResponseHeaderBytes,
 		WriteBufferSize:        DefaultWriteBufferSize,
 		ReadBufferSize:         DefaultReadBufferSize,
+		ShutdownTimeout:        DefaultShutdownTimeout,
+		DialTimeout:            DefaultDialTimeout,
+		KeepAlive:              DefaultKeepAlive,
+		TLSHandshakeTimeout:    DefaultTLSHandshakeTimeout,
+		LatencyBuckets:         append([]config.Duration(nil), DefaultLatencyBuckets...),
+	}
+}
+
+// HedgeConfig enables hedged requests for idempotent requests, nil means disabled.
+// When no response arrives within the delay, up to MaxHedges duplicates are sent one delay apart
+// and the first successful response wins. The delay is the Percentile of the latencies observed
+// for the host once MinSamples were observed, and Delay before that, a zero Delay sends no hedge
+// until then. Hedges are capped to BudgetRatio of the requests. Zero MaxHedges and BudgetRatio
+// use the Default* values.
+type HedgeConfig struct {
+	Delay       config.Duration `yaml:"delay,omitempty" json:"delay,omitempty"`
+	Percentile  float64         `yaml:"percentile,omitempty" json:"percentile,omitempty"`
+	MinSamples  int             `yaml:"minSamples,omitempty" json:"minSamples,omitempty"`
+	MaxHedges   int             `yaml:"maxHedges,omitempty" json:"maxHedges,omitempty"`
+	BudgetRatio float64         `yaml:"budgetRatio,omitempty" json:"budgetRatio,omitempty"`
+}
+
+// BalancerConfig spreads requests to Host over Endpoints, nil means disabled. Host is the logical
+// host of request urls, e.g. users for http://users/path, empty means every request. The scheme,
+// host and path prefix of the picked endpoint replace those of the request, the Host header is the
+// endpoint host too unless PreserveHost, which keeps the logical host or the Host set on the request.
+// Balance is roundRobin, random, weighted or leastOutstanding.
+// An endpoint failing MaxFails times in a row, by transport error or 502, 503, 504, is ejected for
+// EjectDuration, if all endpoints are ejected or unhealthy all are tried.
+// Endpoints are merged with the ones read from EndpointsFile, one url and an optional weight per
+// line, and the ones resolved from the SRV record name, both refreshed every RefreshInterval.
+// SRV endpoints of the lowest priority are used first, the next priority only when all of them are
+// unavailable, configured endpoints have priority 0.
+// HealthCheck and the refresh only run after Open, SRV is resolved first in Open.
+type BalancerConfig struct {
+	Host            string            `yaml:"host,omitempty" json:"host,omitempty"`
+	Endpoints       []*EndpointConfig `yaml:"endpoints,omitempty" json:"endpoints,omitempty"`
+	Balance         string            `yaml:"balance,omitempty" json:"balance,omitempty"`
+	PreserveHost    bool              `yaml:"preserveHost,omitempty" json:"preserveHost,omitempty"`
+	MaxFails        int               `yaml:"maxFails,omitempty" json:"maxFails,omitempty"`
+	EjectDuration   config.Duration   `yaml:"ejectDuration,omitempty" json:"ejectDuration,omitempty"`
+	EndpointsFile   string            `yaml:"endpointsFile,omitempty" json:"endpointsFile,omitempty"`
+	SRV             string            `yaml:"srv,omitempty" json:"srv,omitempty"`
+	SRVScheme       string            `yaml:"srvScheme,omitempty" json:"srvScheme,omitempty"`
+	RefreshInterval config.Duration   `yaml:"refreshInterval,omitempty" json:"refreshInterval,omitempty"`
+
+	HealthCheck *HealthCheckConfig `yaml:"healthCheck,omitempty" json:"healthCheck,omitempty"`
+}
+
+// EndpointConfig is a base url, Weight is used by the weighted balance, 0 means 1.
+type EndpointConfig struct {
+	URL    string `yaml:"url" json:"url"`
+	Weight int    `yaml:"weight,omitempty" json:"weight,omitempty"`
+}
+
+// HealthCheckConfig requests Path of every endpoint each Interval, an endpoint is unhealthy until it
+// answers 2xx within Timeout, 0 means DefaultHealthCheckTimeout.
+type HealthCheckConfig struct {
+	Path     string          `yaml:"path" json:"path"`
+	Interval config.Duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout  config.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// BreakerConfig enables a circuit breaker per upstream host, nil means disabled.
+// A transport error or a 5xx response counts as a failure. The breaker opens when
+// ConsecutiveFailures is reached, or when at least MinRequests were sent within Window and
+// the failure ratio reaches FailureRatio, a zero value disables the respective trigger.
+// After CoolDown, HalfOpenProbes requests are let through and all of them must succeed to close it.
+type BreakerConfig struct {
+	FailureRatio        float64         `yaml:"failureRatio,omitempty" json:"failureRatio,omitempty"`
+	MinRequests         int             `yaml:"minRequests,omitempty" json:"minRequests,omitempty"`
+	Window              config.Duration `yaml:"window,omitempty" json:"window,omitempty"`
+	ConsecutiveFailures int             `yaml:"consecutiveFailures,omitempty" json:"consecutiveFailures,omitempty"`
+	CoolDown            config.Duration `yaml:"coolDown,omitempty" json:"coolDown,omitempty"`
+	HalfOpenProbes      int             `yaml:"halfOpenProbes,omitempty" json:"halfOpenProbes,omitempty"`
+}
+
+func NewRetryConfig() *RetryConfig {
+	return &RetryConfig{
+		MaxAttempts:       DefaultRetryMaxAttempts,
+		InitialBackoff:    DefaultRetryInitialBackoff,
+		MaxBackoff:        DefaultRetryMaxBackoff,
+		Multiplier:        DefaultRetryMultiplier,
+		Jitter:            DefaultRetryJitter,
+		Status:            append([]int(nil), DefaultRetryStatus...),
+		MaxRetryAfter:     DefaultRetryMaxRetryAfter,
+		MaxReplayBodySize: DefaultRetryMaxReplayBodySize,
+	}
+}
+
+func NewHedgeConfig() *HedgeConfig {
+	return &HedgeConfig{
+		Percentile:  DefaultHedgePercentile,
+		MinSamples:  DefaultHedgeMinSamples,
+		MaxHedges:   DefaultHedgeMaxHedges,
+		BudgetRatio: DefaultHedgeBudgetRatio,
+	}
+}
+
+func NewBalancerConfig(host string, endpoints ...string) *BalancerConfig {
+	c := &BalancerConfig{
+		Host:            host,
+		Balance:         DefaultBalance,
+		MaxFails:        DefaultBalanceMaxFails,
+		EjectDuration:   DefaultBalanceEjectDuration,
+		SRVScheme:       DefaultSRVScheme,
+		RefreshInterval: DefaultBalanceRefreshInterval,
+	}
+	for _, e := range endpoints {
+		c.Endpoints = append(c.Endpoints, &EndpointConfig{URL: e})
+	}
+	return c
+}
+
+func NewHealthCheckConfig(path string) *HealthCheckConfig {
+	return &HealthCheckConfig{
+		Path:     path,
+		Interval: DefaultHealthCheckInterval,
+		Timeout:  DefaultHealthCheckTimeout,
+	}
+}
+
+func NewBreakerConfig() *BreakerConfig {
+	return &BreakerConfig{
+		FailureRatio:        DefaultBreakerFailureRatio,
+		MinRequests:         DefaultBreakerMinRequests,
+		Window:              DefaultBreakerWindow,
+		ConsecutiveFailures: DefaultBreakerConsecutiveFailures,
+		CoolDown:            DefaultBreakerCoolDown,
+		HalfOpenProbes:      DefaultBreakerHalfOpenProbes,
+	}
+}
+
+func NewLogConfig() *LogConfig {
+	return &LogConfig{
+		RedactHeaders: append([]string(nil), DefaultLogRedactHeaders...),
+		MaxBodySize:   DefaultLogMaxBodySize,
 	}
 }
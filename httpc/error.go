@@ -0,0 +1,25 @@
+package httpc
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrClosed = errors.New("HttpC closed")
+	// ErrLimitDeadline is returned at once when the rate limit delay would exceed the context deadline.
+	ErrLimitDeadline = errors.New("Rate limit delay exceeds context deadline")
+)
+
+const (
+	ErrShutdownTimeout  = "Shutdown timeout with requests in flight"
+	ErrLoadCA           = "Load CA file fail, file: %s"
+	ErrNoCACert         = "No certificate found in CA file: %s"
+	ErrLoadCert         = "Load client certificate fail, cert: %s, key: %s"
+	ErrInvalidLocalAddr = "Invalid local address: %s"
+	ErrInvalidProxy     = "Invalid proxy: %s"
+	ErrNoEndpoint       = "No endpoint for host: %s"
+	ErrInvalidEndpoint  = "Invalid endpoint: %s"
+	ErrLoadEndpoints    = "Load endpoints fail, file: %s"
+	ErrLookupSRV        = "Lookup SRV fail, name: %s"
+	ErrUnknownBalance   = "Unknown balance: %s"
+)
@@ -0,0 +1,255 @@
+package httpc
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatHedges       = "hedges"
+	StatHedgeWins    = "hedgeWins"
+	StatHedgeWinRate = "hedgeWinRate"
+
+	hedgeSampleSize = 128
+	hedgeBudgetCap  = 10
+)
+
+// latencySamples keeps the latest latencies of a host in a ring.
+type latencySamples struct {
+	buf  []time.Duration
+	next int
+}
+
+func (s *latencySamples) add(d time.Duration) {
+	if len(s.buf) < hedgeSampleSize {
+		s.buf = append(s.buf, d)
+		return
+	}
+	s.buf[s.next] = d
+	s.next = (s.next + 1) % hedgeSampleSize
+}
+
+func (s *latencySamples) percentile(p float64) time.Duration {
+	sorted := make([]time.Duration, len(s.buf))
+	copy(sorted, s.buf)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+type hedgeResult struct {
+	resp    *http.Response
+	err     error
+	idx     int
+	cancel  context.CancelFunc
+	elapsed time.Duration
+}
+
+func (r *hedgeResult) succeeded() bool {
+	return r.err == nil && r.resp.StatusCode < http.StatusInternalServerError
+}
+
+func (r *hedgeResult) discard() {
+	if r.resp != nil {
+		drainBody(r.resp.Body)
+	}
+	r.cancel()
+}
+
+// hedgeTransport sends duplicates of a slow idempotent request and returns the first successful response.
+type hedgeTransport struct {
+	h      *HttpC
+	config *HedgeConfig
+	next   http.RoundTripper
+
+	mu      sync.Mutex
+	budget  float64
+	samples map[string]*latencySamples
+}
+
+func newHedgeTransport(h *HttpC, c *HedgeConfig, next http.RoundTripper) *hedgeTransport {
+	cc := *c
+	c = &cc
+	if c.MaxHedges <= 0 {
+		c.MaxHedges = DefaultHedgeMaxHedges
+	}
+	if c.BudgetRatio <= 0 {
+		c.BudgetRatio = DefaultHedgeBudgetRatio
+	}
+
+	return &hedgeTransport{
+		h:       h,
+		config:  c,
+		next:    next,
+		samples: make(map[string]*latencySamples),
+	}
+}
+
+func (t *hedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	delay := t.delay(req.URL.Host)
+	if isStream(req) || !isIdempotent(req) || !replayable || delay <= 0 {
+		start := time.Now()
+		resp, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.observe(req.URL.Host, time.Since(start))
+		}
+		return resp, err
+	}
+
+	t.earn()
+
+	results := make(chan *hedgeResult, 1+t.config.MaxHedges)
+	cancels := make([]context.CancelFunc, 0, 1+t.config.MaxHedges)
+	start := time.Now()
+	send := func(idx int) error {
+		ctx, cancel := context.WithCancel(req.Context())
+		r := req.Clone(ctx)
+		if idx > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return err
+			}
+			r.Body = body
+		}
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := t.next.RoundTrip(r)
+			results <- &hedgeResult{resp: resp, err: err, idx: idx, cancel: cancel, elapsed: time.Since(start)}
+		}()
+		return nil
+	}
+
+	_ = send(0)
+	sent, pending := 1, 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last *hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if sent > t.config.MaxHedges || !t.spend() {
+				continue
+			}
+			if err := send(sent); err != nil {
+				continue
+			}
+			sent++
+			pending++
+			t.h.stats.Incr(StatHedges, 1)
+			timer.Reset(delay)
+
+		case res := <-results:
+			pending--
+			if !res.succeeded() {
+				if last != nil {
+					last.discard()
+				}
+				last = res
+				continue
+			}
+
+			for i, cancel := range cancels {
+				if i != res.idx {
+					cancel()
+				}
+			}
+			go discardResults(results, pending)
+			if last != nil {
+				last.discard()
+			}
+
+			if res.idx > 0 {
+				t.h.stats.Incr(StatHedgeWins, 1)
+			}
+			t.observe(req.URL.Host, res.elapsed)
+			res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: res.cancel}
+			return res.resp, nil
+		}
+	}
+
+	if last.err != nil {
+		last.cancel()
+		return nil, last.err
+	}
+	last.resp.Body = &cancelBody{ReadCloser: last.resp.Body, cancel: last.cancel}
+	return last.resp, nil
+}
+
+func discardResults(results <-chan *hedgeResult, n int) {
+	for i := 0; i < n; i++ {
+		(<-results).discard()
+	}
+}
+
+// delay returns the percentile latency once enough samples are observed, Delay otherwise.
+func (t *hedgeTransport) delay(host string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.samples[host]
+	if !ok || t.config.Percentile <= 0 || len(s.buf) < t.config.MinSamples || len(s.buf) == 0 {
+		return t.config.Delay.ToDuration()
+	}
+	return s.percentile(t.config.Percentile)
+}
+
+func (t *hedgeTransport) observe(host string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.samples[host]
+	if !ok {
+		s = &latencySamples{}
+		t.samples[host] = s
+	}
+	s.add(d)
+}
+
+// earn adds BudgetRatio of a hedge to the budget for every request eligible for hedging.
+func (t *hedgeTransport) earn() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.budget = math.Min(t.budget+t.config.BudgetRatio, hedgeBudgetCap)
+}
+
+func (t *hedgeTransport) spend() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.budget < 1 {
+		return false
+	}
+	t.budget--
+	return true
+}
+
+func (t *hedgeTransport) export(m map[string]float64) {
+	if hedges := m[StatHedges]; hedges > 0 {
+		m[StatHedgeWinRate] = m[StatHedgeWins] / hedges
+	}
+}
+
+// timeoutTransport bounds a request with timeout until its body is closed, each attempt with
+// Config.AttemptTimeout, or the whole request with Config.Timeout for RoundTripper.
+type timeoutTransport struct {
+	timeout time.Duration
+	next    http.RoundTripper
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
@@ -0,0 +1,128 @@
+package httpc
+
+import (
+	"github.com/donkeywon/gtil/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHedge(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	canceled := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Inc() == 1 {
+			select {
+			case <-r.Context().Done():
+				canceled <- struct{}{}
+			case <-time.After(time.Second):
+			}
+			_, _ = w.Write([]byte("slow"))
+			return
+		}
+		_, _ = w.Write([]byte("fast"))
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Hedge = NewHedgeConfig()
+	c.Hedge.Delay = config.Duration(20 * time.Millisecond)
+	c.Hedge.BudgetRatio = 1
+	h := mustNew(t, c)
+
+	start := time.Now()
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "fast", string(body))
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("loser attempt should be canceled")
+	}
+
+	m := h.Statistics()
+	assert.Equal(t, float64(1), m[StatHedges])
+	assert.Equal(t, float64(1), m[StatHedgeWinRate])
+
+	hits.Store(10)
+	resp, err = h.Post(srv.URL, "text/plain", nil)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, float64(1), h.Statistics()[StatHedges], "non-idempotent requests are not hedged")
+}
+
+func TestHedgeBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Hedge = NewHedgeConfig()
+	c.Hedge.Delay = config.Duration(5 * time.Millisecond)
+	c.Hedge.BudgetRatio = 0.5
+	h := mustNew(t, c)
+
+	for i := 0; i < 4; i++ {
+		resp, err := h.Get(srv.URL)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, float64(2), h.Statistics()[StatHedges])
+
+	for i := 0; i < 4; i++ {
+		resp, err := h.Post(srv.URL, "text/plain", nil)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, float64(2), h.Statistics()[StatHedges], "non-idempotent requests should not earn budget")
+}
+
+func TestHedgePercentile(t *testing.T) {
+	s := &latencySamples{}
+	for i := 1; i <= 200; i++ {
+		s.add(time.Duration(i) * time.Millisecond)
+	}
+	assert.Len(t, s.buf, hedgeSampleSize)
+	assert.Equal(t, 194*time.Millisecond, s.percentile(0.95))
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Inc() == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.AttemptTimeout = config.Duration(50 * time.Millisecond)
+	c.Retry = NewRetryConfig()
+	c.Retry.InitialBackoff = config.Duration(time.Millisecond)
+	h := mustNew(t, c)
+
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, int64(2), hits.Load())
+	assert.Equal(t, float64(1), h.Statistics()[StatErrTimeout])
+}
+
+func TestHedgeZeroConfig(t *testing.T) {
+	h := mustNew(t, NewConfig())
+	ht := newHedgeTransport(h, &HedgeConfig{Delay: config.Duration(50 * time.Millisecond)}, nil)
+	assert.Equal(t, DefaultHedgeMaxHedges, ht.config.MaxHedges)
+	assert.Equal(t, DefaultHedgeBudgetRatio, ht.config.BudgetRatio)
+}
@@ -2,8 +2,13 @@ package httpc
 
 import (
 	"context"
-	"go.uber.org/zap"
+	"github.com/donkeywon/gtil/service"
+	"github.com/donkeywon/gtil/statistics"
+	"github.com/pkg/errors"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
 const (
@@ -11,67 +16,243 @@ const (
 )
 
 type HttpC struct {
+	*service.BaseService
 	*http.Client
 
-	config *Config
+	config    *Config
+	transport *http.Transport
+	// base is transport unless replaced by WithTransport
+	base     http.RoundTripper
+	breaker  *breakerTransport
+	metrics  *metricsTransport
+	hedge    *hedgeTransport
+	balancer *balancer
+	stats    statistics.Statistics
 
-	closed chan struct{}
-	ctx    context.Context
+	// closeMu guards closing against inFlight.Add, so Wait never races with a new request
+	closeMu  sync.RWMutex
+	closing  bool
+	inFlight sync.WaitGroup
+}
+
+// Option customizes an HttpC in New.
+type Option func(*HttpC)
 
-	err error
+// WithTransport replaces the http.Transport built from Config, e.g. by a mock of the httpctest package.
+// Every other layer configured still applies, the TLS, proxy and dialer settings do not.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(h *HttpC) {
+		h.base = rt
+	}
 }
 
-func New(config *Config, ctx context.Context) *HttpC {
-	trans := &http.Transport{
-		DisableKeepAlives:      config.DisableKeepAlives,
-		DisableCompression:     config.DisableCompression,
-		MaxIdleConns:           config.MaxIdleConns,
-		MaxIdleConnsPerHost:    config.MaxIdleConnsPerHost,
-		IdleConnTimeout:        config.IdleConnTimeout.ToDuration(),
-		ResponseHeaderTimeout:  config.ResponseHeaderTimeout.ToDuration(),
-		ExpectContinueTimeout:  config.ExpectContinueTimeout.ToDuration(),
-		MaxResponseHeaderBytes: config.MaxResponseHeaderBytes,
-		WriteBufferSize:        config.WriteBufferSize,
-		ReadBufferSize:         config.ReadBufferSize,
+type streamKey struct{}
+
+// WithStream marks the requests of ctx as streamed, e.g. proxied, retry and hedging send them once,
+// so their body is never buffered.
+func WithStream(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamKey{}, true)
+}
+
+func isStream(req *http.Request) bool {
+	stream, _ := req.Context().Value(streamKey{}).(bool)
+	return stream
+}
+
+func New(config *Config, opts ...Option) (*HttpC, error) {
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HttpC{
+		BaseService: service.NewBase(),
+		config:      config,
+		transport:   transport,
+		base:        transport,
+		stats: statistics.New(
+			StatRequests, StatAttempts, StatErrDNS, StatErrConnect, StatErrTLS, StatErrTimeout, StatErrStatus, StatErrOther,
+			StatConnReused, StatConnNew, StatBreakerOpened, StatBreakerRejected, StatLimitWaited, StatLimitCanceled,
+			StatHedges, StatHedgeWins, StatBalanceEjected, StatHealthCheckFails),
 	}
 
-	c := &http.Client{
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	rt, err := h.buildTransport()
+	if err != nil {
+		return nil, err
+	}
+	h.Client = &http.Client{
 		Timeout:   config.Timeout.ToDuration(),
-		Transport: trans,
+		Transport: rt,
 	}
 
-	return &HttpC{
-		Client: c,
-		config: config,
-		closed: make(chan struct{}),
-		ctx:    ctx,
+	return h, nil
+}
+
+// buildTransport stacks the configured layers on top of the base transport. Limits sit above the
+// attempt timeout and the breaker, so waiting for the client's own limits neither uses up an attempt
+// nor counts as a failure of the upstream.
+func (h *HttpC) buildTransport() (http.RoundTripper, error) {
+	buckets := make([]time.Duration, len(h.config.LatencyBuckets))
+	for i, b := range h.config.LatencyBuckets {
+		buckets[i] = b.ToDuration()
+	}
+	h.metrics = newMetricsTransport(h, buckets, h.config.Log, h.base)
+
+	var rt http.RoundTripper = h.metrics
+	if h.config.AttemptTimeout > 0 {
+		rt = &timeoutTransport{timeout: h.config.AttemptTimeout.ToDuration(), next: rt}
+	}
+	if h.config.Breaker != nil {
+		h.breaker = newBreakerTransport(h, h.config.Breaker, rt)
+		rt = h.breaker
+	}
+	if len(h.config.Limits) > 0 {
+		rt = newLimitTransport(h, h.config.Limits, rt)
+	}
+	if h.config.Balancer != nil {
+		b, err := newBalancer(h, h.config.Balancer, rt)
+		if err != nil {
+			return nil, err
+		}
+		h.balancer = b
+		rt = b
 	}
+	if h.config.Hedge != nil {
+		h.hedge = newHedgeTransport(h, h.config.Hedge, rt)
+		rt = h.hedge
+	}
+	if h.config.Retry != nil {
+		rt = newRetryTransport(h, h.config.Retry, rt)
+	}
+	return &trackTransport{h: h, next: rt}, nil
+}
+
+// RoundTripper returns the transport bounded by Config.Timeout as http.Client does, for callers
+// sending requests without the http.Client, such as httputil.ReverseProxy.
+func (h *HttpC) RoundTripper() http.RoundTripper {
+	if h.Timeout <= 0 {
+		return h.Transport
+	}
+	return &timeoutTransport{timeout: h.Timeout, next: h.Transport}
 }
 
 func (h *HttpC) Name() string {
 	return Name
 }
 
+func (h *HttpC) Statistics() map[string]float64 {
+	m := h.stats.Export()
+	h.metrics.export(m)
+	if h.breaker != nil {
+		h.breaker.export(m)
+	}
+	if h.hedge != nil {
+		h.hedge.export(m)
+	}
+	return m
+}
+
+// Open starts the endpoint refresh and health checks of the balancer, requests can be sent without it.
 func (h *HttpC) Open() error {
+	if h.balancer != nil {
+		return h.balancer.open()
+	}
 	return nil
 }
 
+// Close fails new requests and closes idle connections, requests in flight are not waited.
 func (h *HttpC) Close() error {
+	h.markClosing()
+	h.transport.CloseIdleConnections()
 	return nil
 }
 
+// Shutdown fails new requests and waits at most ShutdownTimeout for requests in flight,
+// a request is in flight until its response body is closed. ShutdownTimeout 0 uses DefaultShutdownTimeout.
 func (h *HttpC) Shutdown() error {
-	return nil
+	h.markClosing()
+
+	timeout := h.config.ShutdownTimeout.ToDuration()
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout.ToDuration()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.inFlight.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		err = errors.New(ErrShutdownTimeout)
+	}
+
+	h.transport.CloseIdleConnections()
+	return err
 }
 
-func (h *HttpC) WithLogger(logger *zap.Logger) {
+func (h *HttpC) markClosing() {
+	if h.balancer != nil {
+		h.balancer.close()
+	}
 
+	h.closeMu.Lock()
+	defer h.closeMu.Unlock()
+	h.closing = true
 }
 
-func (h *HttpC) Statistics() map[string]float64 {
+func (h *HttpC) begin() error {
+	h.closeMu.RLock()
+	defer h.closeMu.RUnlock()
+	if h.closing {
+		return ErrClosed
+	}
+	h.inFlight.Add(1)
 	return nil
 }
 
-func (h *HttpC) LastError() error {
-	return h.err
-}
\ No newline at end of file
+// trackTransport counts requests in flight and rejects requests once the HttpC is closing.
+type trackTransport struct {
+	h    *HttpC
+	next http.RoundTripper
+}
+
+func (t *trackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.h.begin()
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+
+	t.h.stats.Incr(StatRequests, 1)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.h.inFlight.Done()
+		return nil, err
+	}
+
+	resp.Body = &doneBody{ReadCloser: resp.Body, done: t.h.inFlight.Done}
+	return resp, nil
+}
+
+// doneBody calls done once when closed.
+type doneBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,103 @@
+package httpc
+
+import (
+	"github.com/donkeywon/gtil/config"
+	"github.com/donkeywon/gtil/service"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var _ service.Service = (*HttpC)(nil)
+
+func mustNew(t *testing.T, c *Config) *HttpC {
+	h, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func newTextServer(text string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(text))
+	}))
+}
+
+func TestCloseRejectsRequests(t *testing.T) {
+	srv := newTextServer("ok")
+	defer srv.Close()
+
+	h := mustNew(t, NewConfig())
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+
+	assert.NoError(t, h.Close())
+	_, err = h.Get(srv.URL)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestShutdownWaitsInFlight(t *testing.T) {
+	srv := newTextServer("ok")
+	defer srv.Close()
+
+	h := mustNew(t, NewConfig())
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Shutdown()
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned with a response body open")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = resp.Body.Close()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not return after body closed")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	srv := newTextServer("ok")
+	defer srv.Close()
+
+	c := NewConfig()
+	c.ShutdownTimeout = config.Duration(50 * time.Millisecond)
+	h := mustNew(t, c)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Error(t, h.Shutdown())
+}
+
+func TestShutdownZeroTimeout(t *testing.T) {
+	srv := newTextServer("ok")
+	defer srv.Close()
+
+	c := NewConfig()
+	c.ShutdownTimeout = 0
+	h := mustNew(t, c)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = resp.Body.Close()
+	}()
+	assert.NoError(t, h.Shutdown(), "zero ShutdownTimeout should wait DefaultShutdownTimeout")
+}
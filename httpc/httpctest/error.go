@@ -0,0 +1,9 @@
+package httpctest
+
+const (
+	ErrNoRule        = "No rule matches request: %s %s"
+	ErrNoInteraction = "No recorded interaction matches request: %s %s"
+	ErrLoadCassette  = "Load cassette fail, file: %s"
+	ErrSaveCassette  = "Save cassette fail, file: %s"
+	ErrUnknownMode   = "Unknown mode: %d"
+)
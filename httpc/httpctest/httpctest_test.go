@@ -0,0 +1,149 @@
+package httpctest
+
+import (
+	"github.com/donkeywon/gtil/httpc"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type recordT struct {
+	errors []string
+}
+
+func (r *recordT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, format)
+}
+
+func newClient(t *testing.T, rt http.RoundTripper) *httpc.HttpC {
+	h, err := httpc.New(httpc.NewConfig(), httpc.WithTransport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestMock(t *testing.T) {
+	m := NewMock()
+	created := m.On(http.MethodPost, "/users").
+		WithHeader("X-Tenant", "t1").
+		WithJSONBody(map[string]interface{}{"name": "n", "age": 1}).
+		RespondJSON(http.StatusCreated, map[string]string{"id": "1"}).
+		Times(1)
+	m.On(http.MethodGet, "/users/*").WithQuery("full", "1").RespondHeader("X-Id", "2").Respond(http.StatusOK, "user")
+	m.On("", "/down").RespondError(errors.New("connection refused"))
+
+	h := newClient(t, m)
+
+	var out map[string]string
+	err := h.NewRequest(http.MethodPost, "http://svc/users").
+		Header("X-Tenant", "t1").
+		JSON(map[string]interface{}{"age": 1, "name": "n"}).
+		DecodeJSON(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "1"}, out)
+
+	err = h.NewRequest(http.MethodPost, "http://svc/users").Header("X-Tenant", "t1").
+		JSON(map[string]interface{}{"age": 1, "name": "n"}).DecodeJSON(&out)
+	assert.Error(t, err, "rule limited by Times should not match again")
+
+	resp, err := h.Get("http://svc/users/2?full=1")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "user", string(body))
+	assert.Equal(t, "2", resp.Header.Get("X-Id"))
+
+	_, err = h.Get("http://svc/users/2")
+	assert.Error(t, err, "missing query should not match")
+
+	assert.True(t, m.AssertCalls(t, created, 1))
+	assert.Len(t, m.Requests(), 4)
+
+	rt := &recordT{}
+	assert.False(t, m.AssertExpectations(rt), "/down was never called")
+	assert.Len(t, rt.errors, 1)
+
+	_, err = h.Get("http://svc/down")
+	assert.Error(t, err)
+	assert.True(t, m.AssertExpectations(t))
+}
+
+func TestRecorder(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "secret")
+		_, _ = w.Write([]byte(r.URL.Path + " " + string(body) + " " + string(rune('0'+hits))))
+	}))
+	defer srv.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.yaml")
+	rec, err := NewRecorder(cassette, ModeRecordOnce, nil)
+	assert.NoError(t, err)
+	assert.True(t, rec.Recording())
+
+	h := newClient(t, rec)
+	send := func(h *httpc.HttpC) []string {
+		var out []string
+		for _, body := range []string{"a", "a", "b"} {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/echo", strings.NewReader(body))
+			req.Header.Set("Authorization", "secret")
+			resp, err := h.Do(req)
+			if !assert.NoError(t, err) {
+				return out
+			}
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			out = append(out, string(respBody))
+		}
+		return out
+	}
+
+	recorded := send(h)
+	assert.Equal(t, []string{"/echo a 1", "/echo a 2", "/echo b 3"}, recorded)
+	assert.NoError(t, rec.Save())
+
+	raw, _ := ioutil.ReadFile(cassette)
+	assert.NotContains(t, string(raw), "secret")
+
+	srv.Close()
+	rec, err = NewRecorder(cassette, ModeRecordOnce, nil)
+	assert.NoError(t, err)
+	assert.False(t, rec.Recording())
+	assert.Equal(t, recorded, send(newClient(t, rec)))
+
+	_, err = newClient(t, rec).Get(srv.URL + "/other")
+	assert.Error(t, err)
+
+	_, err = NewRecorder(filepath.Join(t.TempDir(), "missing.yaml"), ModeReplay, nil)
+	assert.Error(t, err)
+}
+
+func TestMock_ConfigureWhileServing(t *testing.T) {
+	m := NewMock()
+	rule := m.On(http.MethodGet, "/")
+	h := newClient(t, m)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			resp, err := h.Get("http://svc/")
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		rule.RespondHeader("X-N", "n").Respond(http.StatusOK, "ok")
+	}
+	<-done
+	m.AssertCalls(t, rule, 50)
+}
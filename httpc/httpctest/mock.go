@@ -0,0 +1,311 @@
+// Package httpctest provides round trippers to test code using httpc without a network:
+// Mock answers scripted responses and Recorder records real interactions to a cassette and replays them.
+// Both are injected with httpc.WithTransport.
+package httpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestingT is the part of testing.T used by the assertions.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Rule matches requests and describes the response to them. The zero value of every matcher
+// matches anything, Path ending with * matches by prefix. Rules may be changed while the mock
+// is in use, a request is answered by the rule as it was when the request matched.
+type Rule struct {
+	// mu is the one of the Mock
+	mu *sync.Mutex
+
+	method string
+	path   string
+	query  map[string]string
+	header map[string]string
+	body   func([]byte) bool
+
+	status     int
+	respHeader http.Header
+	respBody   []byte
+	err        error
+	delay      time.Duration
+	times      int
+
+	calls int
+}
+
+// set applies fn under the lock of the Mock.
+func (r *Rule) set(fn func()) *Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn()
+	return r
+}
+
+func (r *Rule) WithQuery(key, value string) *Rule {
+	return r.set(func() { r.query[key] = value })
+}
+
+func (r *Rule) WithHeader(key, value string) *Rule {
+	return r.set(func() { r.header[key] = value })
+}
+
+func (r *Rule) WithBody(body string) *Rule {
+	return r.WithBodyFunc(func(b []byte) bool {
+		return string(b) == body
+	})
+}
+
+// WithJSONBody matches a body equal to v once both are decoded, so formatting and key order do not matter.
+func (r *Rule) WithJSONBody(v interface{}) *Rule {
+	buf, _ := json.Marshal(v)
+	var want interface{}
+	_ = json.Unmarshal(buf, &want)
+	return r.WithBodyFunc(func(b []byte) bool {
+		var got interface{}
+		return json.Unmarshal(b, &got) == nil && reflect.DeepEqual(want, got)
+	})
+}
+
+func (r *Rule) WithBodyFunc(fn func(body []byte) bool) *Rule {
+	return r.set(func() { r.body = fn })
+}
+
+func (r *Rule) Respond(status int, body string) *Rule {
+	return r.set(func() {
+		r.status = status
+		r.respBody = []byte(body)
+	})
+}
+
+func (r *Rule) RespondJSON(status int, v interface{}) *Rule {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return r.RespondError(err)
+	}
+	return r.set(func() {
+		r.status = status
+		r.respBody = buf
+		r.respHeader.Set("Content-Type", "application/json")
+	})
+}
+
+// RespondFile answers the content of a fixture file, a missing file answers an error.
+func (r *Rule) RespondFile(status int, path string) *Rule {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return r.RespondError(err)
+	}
+	return r.set(func() {
+		r.status = status
+		r.respBody = buf
+	})
+}
+
+func (r *Rule) RespondHeader(key, value string) *Rule {
+	return r.set(func() { r.respHeader.Add(key, value) })
+}
+
+// RespondError makes the round trip fail with err, e.g. to simulate a connection error.
+func (r *Rule) RespondError(err error) *Rule {
+	return r.set(func() { r.err = err })
+}
+
+// Delay waits before answering, or until the request context is done.
+func (r *Rule) Delay(d time.Duration) *Rule {
+	return r.set(func() { r.delay = d })
+}
+
+// Times limits the requests matched by the rule to n, later ones fall through to the next rules.
+// AssertExpectations then expects exactly n calls.
+func (r *Rule) Times(n int) *Rule {
+	return r.set(func() { r.times = n })
+}
+
+func (r *Rule) String() string {
+	return r.method + " " + r.path
+}
+
+func (r *Rule) match(req *http.Request, body []byte) bool {
+	if r.times > 0 && r.calls >= r.times {
+		return false
+	}
+	if r.method != "" && r.method != req.Method {
+		return false
+	}
+	if strings.HasSuffix(r.path, "*") {
+		if !strings.HasPrefix(req.URL.Path, strings.TrimSuffix(r.path, "*")) {
+			return false
+		}
+	} else if r.path != "" && r.path != req.URL.Path {
+		return false
+	}
+	for k, v := range r.query {
+		if req.URL.Query().Get(k) != v {
+			return false
+		}
+	}
+	for k, v := range r.header {
+		if req.Header.Get(k) != v {
+			return false
+		}
+	}
+	return r.body == nil || r.body(body)
+}
+
+func (r *Rule) response(req *http.Request) *http.Response {
+	header := r.respHeader.Clone()
+	header.Set("Content-Length", strconv.Itoa(len(r.respBody)))
+	return &http.Response{
+		Status:        strconv.Itoa(r.status) + " " + http.StatusText(r.status),
+		StatusCode:    r.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.respBody)),
+		ContentLength: int64(len(r.respBody)),
+		Request:       req,
+	}
+}
+
+// Mock is a scripted http.RoundTripper, rules are tried in the order they were added and a request
+// matching none of them fails, or is sent to Fallback if set.
+type Mock struct {
+	Fallback http.RoundTripper
+
+	mu       sync.Mutex
+	rules    []*Rule
+	requests []*http.Request
+}
+
+func NewMock() *Mock {
+	return &Mock{}
+}
+
+// On adds a rule answering 200 with an empty body until told otherwise,
+// an empty method or path matches any.
+func (m *Mock) On(method, path string) *Rule {
+	r := &Rule{
+		mu:         &m.mu,
+		method:     method,
+		path:       path,
+		query:      make(map[string]string),
+		header:     make(map[string]string),
+		status:     http.StatusOK,
+		respHeader: make(http.Header),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = append(m.rules, r)
+	return r
+}
+
+func (m *Mock) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rule := m.find(req, body)
+	if rule == nil {
+		if m.Fallback != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return m.Fallback.RoundTrip(req)
+		}
+		return nil, errors.Errorf(ErrNoRule, req.Method, req.URL.String())
+	}
+
+	if rule.delay > 0 {
+		timer := time.NewTimer(rule.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if rule.err != nil {
+		return nil, rule.err
+	}
+	return rule.response(req), nil
+}
+
+func (m *Mock) find(req *http.Request, body []byte) *Rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recorded := req.Clone(req.Context())
+	recorded.Body = ioutil.NopCloser(bytes.NewReader(body))
+	m.requests = append(m.requests, recorded)
+
+	for _, r := range m.rules {
+		if r.match(req, body) {
+			r.calls++
+			// answer from a copy, the rule may be changed while the response is built
+			answer := *r
+			answer.respHeader = r.respHeader.Clone()
+			return &answer
+		}
+	}
+	return nil
+}
+
+// Requests returns every request received, their bodies can be read again.
+func (m *Mock) Requests() []*http.Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*http.Request(nil), m.requests...)
+}
+
+// Calls returns how many requests the rule answered.
+func (m *Mock) Calls(r *Rule) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return r.calls
+}
+
+// AssertCalls checks that the rule answered n requests.
+func (m *Mock) AssertCalls(t TestingT, r *Rule, n int) bool {
+	if calls := m.Calls(r); calls != n {
+		t.Errorf("%s: expected %d calls, got %d", r, n, calls)
+		return false
+	}
+	return true
+}
+
+// AssertExpectations checks that every rule was called, exactly Times if set.
+func (m *Mock) AssertExpectations(t TestingT) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ok := true
+	for _, r := range m.rules {
+		switch {
+		case r.times > 0 && r.calls != r.times:
+			t.Errorf("%s: expected %d calls, got %d", r, r.times, r.calls)
+			ok = false
+		case r.times == 0 && r.calls == 0:
+			t.Errorf("%s: expected to be called", r)
+			ok = false
+		}
+	}
+	return ok
+}
@@ -0,0 +1,217 @@
+package httpctest
+
+import (
+	"bytes"
+	"github.com/donkeywon/gtil/httpc"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+type Mode int
+
+const (
+	// ModeReplay answers recorded interactions only, a request not recorded fails.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the real transport and records them, replacing the cassette on Save.
+	ModeRecord
+	// ModeRecordOnce replays when the cassette exists, and records it otherwise.
+	ModeRecordOnce
+
+	redacted = "[REDACTED]"
+)
+
+type RecordedRequest struct {
+	Method string      `yaml:"method"`
+	URL    string      `yaml:"url"`
+	Header http.Header `yaml:"header,omitempty"`
+	Body   string      `yaml:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status int         `yaml:"status"`
+	Header http.Header `yaml:"header,omitempty"`
+	Body   string      `yaml:"body,omitempty"`
+}
+
+type Interaction struct {
+	Request  *RecordedRequest  `yaml:"request"`
+	Response *RecordedResponse `yaml:"response"`
+}
+
+// Cassette is the yaml file holding interactions in the order they were recorded.
+type Cassette struct {
+	Interactions []*Interaction `yaml:"interactions"`
+}
+
+// Recorder is an http.RoundTripper recording to or replaying from a cassette. When replaying, a request
+// gets the first interaction not replayed yet with the same method, url and body, so a sequence of
+// identical requests replays the sequence of recorded responses.
+type Recorder struct {
+	// RedactHeaders are recorded with a placeholder value, httpc.DefaultLogRedactHeaders by default.
+	RedactHeaders []string
+
+	path string
+	mode Mode
+	real http.RoundTripper
+
+	mu       sync.Mutex
+	cassette *Cassette
+	replayed []bool
+}
+
+// NewRecorder opens the cassette at path, real is the transport used to record, nil means http.DefaultTransport.
+func NewRecorder(path string, mode Mode, real http.RoundTripper) (*Recorder, error) {
+	if real == nil {
+		real = http.DefaultTransport
+	}
+
+	r := &Recorder{
+		RedactHeaders: append([]string(nil), httpc.DefaultLogRedactHeaders...),
+		path:          path,
+		mode:          mode,
+		real:          real,
+		cassette:      &Cassette{},
+	}
+
+	switch mode {
+	case ModeRecord:
+		return r, nil
+	case ModeRecordOnce:
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			r.mode = ModeRecord
+			return r, nil
+		}
+		r.mode = ModeReplay
+	case ModeReplay:
+	default:
+		return nil, errors.Errorf(ErrUnknownMode, mode)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrLoadCassette, path)
+	}
+	if err = yaml.Unmarshal(buf, r.cassette); err != nil {
+		return nil, errors.Wrapf(err, ErrLoadCassette, path)
+	}
+	r.replayed = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// Recording reports whether requests are sent to the real transport.
+func (r *Recorder) Recording() bool {
+	return r.mode == ModeRecord
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, body)
+	}
+	return r.record(req, body)
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, it := range r.cassette.Interactions {
+		if r.replayed[i] || it.Request.Method != req.Method || it.Request.URL != req.URL.String() ||
+			it.Request.Body != string(body) {
+			continue
+		}
+		r.replayed[i] = true
+
+		resp := it.Response
+		return &http.Response{
+			Status:        strconv.Itoa(resp.Status) + " " + http.StatusText(resp.Status),
+			StatusCode:    resp.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        resp.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(resp.Body))),
+			ContentLength: int64(len(resp.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, errors.Errorf(ErrNoInteraction, req.Method, req.URL.String())
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, err := r.real.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+
+	it := &Interaction{
+		Request: &RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: r.redact(req.Header),
+			Body:   string(body),
+		},
+		Response: &RecordedResponse{
+			Status: resp.StatusCode,
+			Header: r.redact(resp.Header),
+			Body:   string(respBody),
+		},
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, it)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) redact(header http.Header) http.Header {
+	out := header.Clone()
+	for _, k := range r.RedactHeaders {
+		if _, ok := out[http.CanonicalHeaderKey(k)]; ok {
+			out.Set(k, redacted)
+		}
+	}
+	return out
+}
+
+// Save writes the recorded interactions to the cassette, it does nothing when replaying.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	buf, err := yaml.Marshal(r.cassette)
+	r.mu.Unlock()
+	if err != nil {
+		return errors.Wrapf(err, ErrSaveCassette, r.path)
+	}
+	if err = ioutil.WriteFile(r.path, buf, 0644); err != nil {
+		return errors.Wrapf(err, ErrSaveCassette, r.path)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package httpc
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	LimitHostGlobal  = ""
+	LimitHostPerHost = "*"
+
+	StatLimitWaited   = "limitWaited"
+	StatLimitCanceled = "limitCanceled"
+)
+
+type limitBucket struct {
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+}
+
+func newLimitBucket(c *LimitConfig) *limitBucket {
+	b := &limitBucket{}
+	if c.Rate > 0 {
+		b.limiter = rate.NewLimiter(rate.Limit(c.Rate), c.burst())
+	}
+	if c.MaxInFlight > 0 {
+		b.inFlight = make(chan struct{}, c.MaxInFlight)
+	}
+	return b
+}
+
+type limitRule struct {
+	config *LimitConfig
+	global *limitBucket
+
+	// hosts are never swept, the hosts a client talks to are expected to be few
+	mu    sync.Mutex
+	hosts map[string]*limitBucket
+}
+
+func newLimitRule(c *LimitConfig) *limitRule {
+	lr := &limitRule{config: c}
+	if c.Host == LimitHostPerHost {
+		lr.hosts = make(map[string]*limitBucket)
+	} else {
+		lr.global = newLimitBucket(c)
+	}
+	return lr
+}
+
+func (lr *limitRule) bucket(req *http.Request) *limitBucket {
+	switch lr.config.Host {
+	case LimitHostGlobal:
+		return lr.global
+	case LimitHostPerHost:
+		lr.mu.Lock()
+		defer lr.mu.Unlock()
+		b, ok := lr.hosts[req.URL.Host]
+		if !ok {
+			b = newLimitBucket(lr.config)
+			lr.hosts[req.URL.Host] = b
+		}
+		return b
+	default:
+		if lr.config.Host == req.URL.Host || lr.config.Host == req.URL.Hostname() {
+			return lr.global
+		}
+		return nil
+	}
+}
+
+// limitTransport makes every request wait for the budgets of all matching rules.
+type limitTransport struct {
+	h     *HttpC
+	rules []*limitRule
+	next  http.RoundTripper
+}
+
+func newLimitTransport(h *HttpC, configs []*LimitConfig, next http.RoundTripper) *limitTransport {
+	t := &limitTransport{h: h, next: next}
+	for _, c := range configs {
+		t.rules = append(t.rules, newLimitRule(c))
+	}
+	return t
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.acquire(req.Context(), req)
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		t.h.stats.Incr(StatLimitCanceled, 1)
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &doneBody{ReadCloser: resp.Body, done: release}
+	return resp, nil
+}
+
+// acquire waits for a token and a slot of every matching rule, the returned func releases the slots.
+func (t *limitTransport) acquire(ctx context.Context, req *http.Request) (func(), error) {
+	var slots []chan struct{}
+	release := func() {
+		for _, slot := range slots {
+			<-slot
+		}
+	}
+
+	waited := false
+	for _, lr := range t.rules {
+		b := lr.bucket(req)
+		if b == nil {
+			continue
+		}
+
+		if b.limiter != nil {
+			r := b.limiter.Reserve()
+			if d := r.Delay(); d > 0 {
+				if deadline, ok := ctx.Deadline(); ok && d > time.Until(deadline) {
+					r.Cancel()
+					release()
+					return nil, ErrLimitDeadline
+				}
+				waited = true
+				if err := sleep(ctx, d); err != nil {
+					r.Cancel()
+					release()
+					return nil, err
+				}
+			}
+		}
+
+		if b.inFlight != nil {
+			select {
+			case b.inFlight <- struct{}{}:
+			default:
+				waited = true
+				select {
+				case b.inFlight <- struct{}{}:
+				case <-ctx.Done():
+					release()
+					return nil, ctx.Err()
+				}
+			}
+			slots = append(slots, b.inFlight)
+		}
+	}
+
+	if waited {
+		t.h.stats.Incr(StatLimitWaited, 1)
+	}
+	return release, nil
+}
@@ -0,0 +1,104 @@
+package httpc
+
+import (
+	"context"
+	"github.com/donkeywon/gtil/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimitRate(t *testing.T) {
+	srv := newTextServer("ok")
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Limits = []*LimitConfig{{Rate: 20, Burst: 1}}
+	h := mustNew(t, c)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := h.Get(srv.URL)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.True(t, time.Since(start) >= 90*time.Millisecond, "3 requests at 20/s with burst 1 take 100ms")
+	assert.Equal(t, float64(2), h.Statistics()[StatLimitWaited])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start = time.Now()
+	_, err = h.Do(req)
+	assert.ErrorIs(t, err, ErrLimitDeadline, "waiting should be bounded by the context")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Millisecond), "a delay past the deadline should fail at once")
+	assert.Equal(t, float64(1), h.Statistics()[StatLimitCanceled])
+}
+
+func TestLimitInFlightPerHost(t *testing.T) {
+	current, peak := atomic.NewInt64(0), atomic.NewInt64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := current.Inc()
+		for {
+			p := peak.Load()
+			if n <= p || peak.CAS(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		current.Dec()
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.MaxIdleConnsPerHost = 10
+	c.Limits = []*LimitConfig{{Host: LimitHostPerHost, MaxInFlight: 2}, {Host: "other.test", MaxInFlight: 1}}
+	h := mustNew(t, c)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := h.Get(srv.URL)
+			assert.NoError(t, err)
+			_ = resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(2), peak.Load())
+}
+
+func TestLimitDoesNotTripBreaker(t *testing.T) {
+	srv := newTextServer("ok")
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Limits = []*LimitConfig{{Rate: 1, Burst: 1}}
+	c.AttemptTimeout = config.Duration(10 * time.Millisecond)
+	c.Breaker = NewBreakerConfig()
+	c.Breaker.ConsecutiveFailures = 1
+	h := mustNew(t, c)
+
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	_, err = h.Do(req)
+	assert.ErrorIs(t, err, ErrLimitDeadline)
+
+	u, _ := url.Parse(srv.URL)
+	assert.Equal(t, float64(BreakerClosed), h.Statistics()[statBreakerStatePrefix+u.Host], "limit rejection should not trip the breaker")
+	assert.Equal(t, float64(0), h.Statistics()[StatBreakerOpened])
+}
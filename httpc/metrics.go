@@ -0,0 +1,282 @@
+package httpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StatRequests counts requests sent by the client, StatAttempts every attempt of them made by
+	// retries and hedges, the error counters and the latency histograms are per attempt too
+	StatRequests   = "requests"
+	StatAttempts   = "attempts"
+	StatErrDNS     = "errDNS"
+	StatErrConnect = "errConnect"
+	StatErrTLS     = "errTLS"
+	StatErrTimeout = "errTimeout"
+	StatErrStatus  = "errStatus"
+	StatErrOther   = "errOther"
+	StatConnReused = "connReused"
+	StatConnNew    = "connNew"
+
+	// StatConnReuseRatio is derived from StatConnReused and StatConnNew
+	StatConnReuseRatio = "connReuseRatio"
+
+	// statLatencyPrefix is followed by the host, then ".le.<bucket>", ".count" or ".sum" in seconds
+	statLatencyPrefix = "attemptLatency."
+
+	redacted = "[REDACTED]"
+)
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// metricsTransport records every attempt sent by the base transport, and logs it when LogConfig is set.
+type metricsTransport struct {
+	h       *HttpC
+	buckets []time.Duration
+	log     *LogConfig
+	redact  map[string]struct{}
+	next    http.RoundTripper
+
+	mu      sync.Mutex
+	latency map[string]*histogram
+}
+
+func newMetricsTransport(h *HttpC, buckets []time.Duration, log *LogConfig, next http.RoundTripper) *metricsTransport {
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	t := &metricsTransport{
+		h:       h,
+		buckets: buckets,
+		log:     log,
+		next:    next,
+		latency: make(map[string]*histogram),
+	}
+	if log != nil {
+		t.redact = make(map[string]struct{}, len(log.RedactHeaders))
+		for _, name := range log.RedactHeaders {
+			t.redact[http.CanonicalHeaderKey(name)] = struct{}{}
+		}
+	}
+	return t
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.h.stats.Incr(StatConnReused, 1)
+			} else {
+				t.h.stats.Incr(StatConnNew, 1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	logging := t.log != nil && t.h.Core().Enabled(zap.DebugLevel)
+	if logging {
+		t.logRequest(req)
+	}
+
+	t.h.stats.Incr(StatAttempts, 1)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	t.observe(req.URL.Host, elapsed)
+	if err != nil {
+		t.h.stats.Incr(classifyError(err), 1)
+	} else if resp.StatusCode >= http.StatusInternalServerError {
+		t.h.stats.Incr(StatErrStatus, 1)
+	}
+
+	if logging {
+		t.logResponse(req, resp, err, elapsed)
+	}
+	return resp, err
+}
+
+func (t *metricsTransport) observe(host string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hist, ok := t.latency[host]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(t.buckets))}
+		t.latency[host] = hist
+	}
+
+	for i, bound := range t.buckets {
+		if d <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += d.Seconds()
+}
+
+func (t *metricsTransport) export(m map[string]float64) {
+	reused, created := m[StatConnReused], m[StatConnNew]
+	if reused+created > 0 {
+		m[StatConnReuseRatio] = reused / (reused + created)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for host, hist := range t.latency {
+		prefix := statLatencyPrefix + host
+		for i, bound := range t.buckets {
+			m[prefix+".le."+bound.String()] = float64(hist.counts[i])
+		}
+		m[prefix+".count"] = float64(hist.count)
+		m[prefix+".sum"] = hist.sum
+	}
+}
+
+func (t *metricsTransport) logRequest(req *http.Request) {
+	fields := []zap.Field{
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.Redacted()),
+		zap.Any("header", t.redactHeader(req.Header)),
+	}
+	if t.log.MaxBodySize > 0 && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			buf, _ := ioutil.ReadAll(io.LimitReader(body, int64(t.log.MaxBodySize)+1))
+			_ = body.Close()
+			fields = append(fields, zap.String("body", t.truncate(buf)))
+		}
+	}
+	t.h.Debug("Send request", fields...)
+}
+
+func (t *metricsTransport) logResponse(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	fields := []zap.Field{
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.Redacted()),
+		zap.Duration("elapsed", elapsed),
+	}
+	if err != nil {
+		t.h.Debug("Request failed", append(fields, zap.Error(err))...)
+		return
+	}
+
+	fields = append(fields,
+		zap.Int("status", resp.StatusCode),
+		zap.Any("header", t.redactHeader(resp.Header)))
+	if t.log.MaxBodySize <= 0 {
+		t.h.Debug("Receive response", fields...)
+		return
+	}
+
+	// the body is logged as the caller reads it, reading it here would block on streamed responses
+	resp.Body = &logBody{
+		ReadCloser: resp.Body,
+		max:        t.log.MaxBodySize + 1,
+		log: func(buf []byte) {
+			t.h.Debug("Receive response", append(fields, zap.String("body", t.truncate(buf)))...)
+		},
+	}
+}
+
+// logBody keeps the first max bytes read from the body and logs them once on EOF or Close.
+type logBody struct {
+	io.ReadCloser
+	buf  []byte
+	max  int
+	log  func(buf []byte)
+	once sync.Once
+}
+
+func (b *logBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remain := b.max - len(b.buf); remain > 0 {
+		if remain > n {
+			remain = n
+		}
+		b.buf = append(b.buf, p[:remain]...)
+	}
+	if err == io.EOF {
+		b.flush()
+	}
+	return n, err
+}
+
+func (b *logBody) Close() error {
+	b.flush()
+	return b.ReadCloser.Close()
+}
+
+func (b *logBody) flush() {
+	b.once.Do(func() {
+		b.log(b.buf)
+	})
+}
+
+func (t *metricsTransport) redactHeader(header http.Header) http.Header {
+	out := make(http.Header, len(header))
+	for k, v := range header {
+		if _, ok := t.redact[k]; ok {
+			out[k] = []string{redacted}
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func (t *metricsTransport) truncate(buf []byte) string {
+	if len(buf) <= t.log.MaxBodySize {
+		return string(buf)
+	}
+	return string(buf[:t.log.MaxBodySize]) + "...(truncated)"
+}
+
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return StatErrDNS
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return StatErrTimeout
+	}
+
+	if isTLSError(err) {
+		return StatErrTLS
+	}
+
+	if isDialError(err) {
+		return StatErrConnect
+	}
+	return StatErrOther
+}
+
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "tls: ") || strings.Contains(err.Error(), ": tls: ")
+}
@@ -0,0 +1,126 @@
+package httpc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	h := mustNew(t, NewConfig())
+	for _, path := range []string{"/", "/", "/fail"} {
+		resp, err := h.Get(srv.URL + path)
+		assert.NoError(t, err)
+		_, _ = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	u, _ := url.Parse(srv.URL)
+	m := h.Statistics()
+	assert.Equal(t, float64(3), m[StatRequests])
+	assert.Equal(t, float64(3), m[StatAttempts])
+	assert.Equal(t, float64(1), m[StatErrStatus])
+	assert.Equal(t, float64(1), m[StatConnNew])
+	assert.Equal(t, float64(2), m[StatConnReused])
+	assert.InDelta(t, 2.0/3, m[StatConnReuseRatio], 0.001)
+	assert.Equal(t, float64(3), m[statLatencyPrefix+u.Host+".count"])
+	assert.Equal(t, float64(3), m[statLatencyPrefix+u.Host+".le.10s"])
+}
+
+func TestClassifyError(t *testing.T) {
+	h := mustNew(t, NewConfig())
+	_, err := h.Get("http://gtil.invalid/")
+	assert.Error(t, err)
+	assert.Equal(t, StatErrDNS, classifyError(err))
+	assert.Equal(t, float64(1), h.Statistics()[StatErrDNS])
+}
+
+func TestLogRedaction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := NewConfig()
+	c.Log = NewLogConfig()
+	c.Log.MaxBodySize = 10
+	h := mustNew(t, c)
+	h.Logger = zap.New(core)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("request body"))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := h.Do(req)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Len(t, body, 100)
+
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	reqFields := entries[0].ContextMap()
+	assert.Equal(t, "request bo...(truncated)", reqFields["body"])
+	assert.Equal(t, http.Header{"Authorization": {redacted}}, reqFields["header"])
+	respFields := entries[1].ContextMap()
+	assert.Equal(t, strings.Repeat("x", 10)+"...(truncated)", respFields["body"])
+	assert.Equal(t, []string{redacted}, respFields["header"].(http.Header)["Set-Cookie"])
+	assert.NotContains(t, entries[0].Message+entries[1].Message, "secret")
+}
+
+func TestLogStreamingResponse(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("head"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := NewConfig()
+	c.Log = NewLogConfig()
+	c.Log.MaxBodySize = 1024
+	h := mustNew(t, c)
+	h.Logger = zap.New(core)
+
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := h.Get(srv.URL)
+		assert.NoError(t, err)
+		done <- resp
+	}()
+
+	var resp *http.Response
+	select {
+	case resp = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("logging should not wait for the whole response body")
+	}
+
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(resp.Body, buf)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	entries := logs.FilterMessage("Receive response").All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "head", entries[0].ContextMap()["body"])
+}
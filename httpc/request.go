@@ -0,0 +1,322 @@
+package httpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// maxErrorBodySize limits the body kept by StatusError
+	maxErrorBodySize = 64 << 10
+
+	contentTypeJSON = "application/json"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// StatusError is returned by the decode helpers when the response status is not 2xx.
+// Body holds at most 64KB of the response body.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("unexpected status: %s", e.Status)
+	}
+	return fmt.Sprintf("unexpected status: %s, body: %s", e.Status, e.Body)
+}
+
+// MultipartFile is a file part of a multipart body.
+type MultipartFile struct {
+	Field    string
+	Filename string
+	Reader   io.Reader
+}
+
+// Request builds a request sent by its HttpC. Errors happened while building are
+// deferred to Build, Do and the decode helpers.
+type Request struct {
+	h *HttpC
+
+	method      string
+	path        string
+	pathParams  map[string]string
+	query       url.Values
+	header      http.Header
+	body        []byte
+	contentType string
+	ctx         context.Context
+	timeout     time.Duration
+	err         error
+}
+
+// NewRequest starts building a request. path is resolved against Config.BaseURL unless it is
+// an absolute url, it may contain {name} placeholders filled by PathParam.
+func (h *HttpC) NewRequest(method, path string) *Request {
+	return &Request{
+		h:          h,
+		method:     method,
+		path:       path,
+		pathParams: make(map[string]string),
+		query:      make(url.Values),
+		header:     make(http.Header),
+		ctx:        context.Background(),
+	}
+}
+
+func (r *Request) PathParam(name, value string) *Request {
+	r.pathParams[name] = value
+	return r
+}
+
+func (r *Request) Query(key, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+func (r *Request) Header(key, value string) *Request {
+	r.header.Add(key, value)
+	return r
+}
+
+func (r *Request) Context(ctx context.Context) *Request {
+	r.ctx = ctx
+	return r
+}
+
+// Timeout bounds the whole request including reading the response body, 0 means no timeout
+// besides Config.Timeout.
+func (r *Request) Timeout(d time.Duration) *Request {
+	r.timeout = d
+	return r
+}
+
+// Body sets a raw body, an empty contentType leaves the Content-Type header unset.
+func (r *Request) Body(body io.Reader, contentType string) *Request {
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		r.setErr(err)
+		return r
+	}
+	r.body = buf
+	r.contentType = contentType
+	return r
+}
+
+func (r *Request) JSON(v interface{}) *Request {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		r.setErr(err)
+		return r
+	}
+	r.body = buf
+	r.contentType = contentTypeJSON
+	return r
+}
+
+func (r *Request) Form(values url.Values) *Request {
+	r.body = []byte(values.Encode())
+	r.contentType = contentTypeForm
+	return r
+}
+
+func (r *Request) Multipart(fields map[string]string, files ...*MultipartFile) *Request {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			r.setErr(err)
+			return r
+		}
+	}
+	for _, f := range files {
+		part, err := w.CreateFormFile(f.Field, f.Filename)
+		if err != nil {
+			r.setErr(err)
+			return r
+		}
+		if _, err = io.Copy(part, f.Reader); err != nil {
+			r.setErr(err)
+			return r
+		}
+	}
+	if err := w.Close(); err != nil {
+		r.setErr(err)
+		return r
+	}
+
+	r.body = buf.Bytes()
+	r.contentType = w.FormDataContentType()
+	return r
+}
+
+func (r *Request) setErr(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Build returns the http.Request and the cancel func of its timeout, which must be called
+// once the response is consumed.
+func (r *Request) Build() (*http.Request, context.CancelFunc, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	u, err := r.url()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := r.ctx, context.CancelFunc(func() {})
+	if r.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+	}
+
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if r.contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	return req, cancel, nil
+}
+
+func (r *Request) url() (string, error) {
+	path := r.path
+	for k, v := range r.pathParams {
+		path = strings.ReplaceAll(path, "{"+k+"}", url.PathEscape(v))
+	}
+
+	// an absolute path is sent as is, a relative one, which may not parse alone, is joined to BaseURL
+	u, err := url.Parse(path)
+	if base := r.h.config.BaseURL; base != "" && (err != nil || !u.IsAbs()) {
+		u, err = url.Parse(strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/"))
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(r.query) > 0 {
+		q := u.Query()
+		for k, v := range r.query {
+			q[k] = append(q[k], v...)
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// Do sends the request, the caller must close the response body.
+func (r *Request) Do() (*http.Response, error) {
+	req, cancel, err := r.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.h.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// Bytes sends the request and returns the body of a 2xx response, or a *StatusError.
+func (r *Request) Bytes() ([]byte, error) {
+	resp, err := r.Do()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = CheckStatus(resp); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// DecodeJSON sends the request and unmarshals the body of a 2xx response into v,
+// a nil v discards the body. Other statuses return a *StatusError.
+func (r *Request) DecodeJSON(v interface{}) error {
+	if r.header.Get("Accept") == "" {
+		r.header.Set("Accept", contentTypeJSON)
+	}
+
+	resp, err := r.Do()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return DecodeJSON(resp, v)
+}
+
+// CheckStatus returns a *StatusError carrying the body if the status is not 2xx, the body
+// is consumed in that case.
+func CheckStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       body,
+	}
+}
+
+// DecodeJSON checks the status with CheckStatus and unmarshals the body into v,
+// a nil v or an empty body leaves v untouched. It does not close the body.
+func DecodeJSON(resp *http.Response, v interface{}) error {
+	if err := CheckStatus(resp); err != nil {
+		return err
+	}
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	err := json.NewDecoder(resp.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// cancelBody releases the timeout of a Request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
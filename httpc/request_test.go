@@ -0,0 +1,134 @@
+package httpc
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echoed struct {
+	Method      string      `json:"method"`
+	Path        string      `json:"path"`
+	Query       url.Values  `json:"query"`
+	Header      http.Header `json:"header"`
+	Body        string      `json:"body"`
+	ContentType string      `json:"contentType"`
+}
+
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/fail" {
+			http.Error(w, "broken", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/api/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.NewEncoder(w).Encode(&echoed{
+			Method:      r.Method,
+			Path:        r.URL.EscapedPath(),
+			Query:       r.URL.Query(),
+			Header:      r.Header,
+			Body:        string(body),
+			ContentType: r.Header.Get("Content-Type"),
+		})
+	}))
+}
+
+func TestRequestBuilder(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	c := NewConfig()
+	c.BaseURL = srv.URL + "/api/"
+	h := mustNew(t, c)
+
+	out := &echoed{}
+	err := h.NewRequest(http.MethodPost, "/users/{id}/items").
+		PathParam("id", "a b").
+		Query("q", "1").
+		Query("q", "2").
+		Header("X-Trace", "t").
+		JSON(map[string]int{"n": 1}).
+		DecodeJSON(out)
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPost, out.Method)
+	assert.Equal(t, "/api/users/a%20b/items", out.Path)
+	assert.Equal(t, []string{"1", "2"}, out.Query["q"])
+	assert.Equal(t, "t", out.Header.Get("X-Trace"))
+	assert.Equal(t, contentTypeJSON, out.Header.Get("Accept"))
+	assert.Equal(t, `{"n":1}`, out.Body)
+	assert.Equal(t, contentTypeJSON, out.ContentType)
+
+	err = h.NewRequest(http.MethodPut, srv.URL+"/other").
+		Form(url.Values{"k": {"v"}}).
+		DecodeJSON(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "/other", out.Path)
+	assert.Equal(t, "k=v", out.Body)
+	assert.Equal(t, contentTypeForm, out.ContentType)
+
+	err = h.NewRequest(http.MethodPost, "upload").
+		Multipart(map[string]string{"name": "n"}, &MultipartFile{Field: "file", Filename: "a.txt", Reader: strings.NewReader("content")}).
+		DecodeJSON(out)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out.ContentType, "multipart/form-data; boundary="))
+	assert.Contains(t, out.Body, `filename="a.txt"`)
+	assert.Contains(t, out.Body, "content")
+}
+
+func TestRequestStatusError(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	c := NewConfig()
+	c.BaseURL = srv.URL + "/api"
+	h := mustNew(t, c)
+
+	_, err := h.NewRequest(http.MethodGet, "fail").Bytes()
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	assert.Equal(t, "broken\n", string(statusErr.Body))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := newEchoServer()
+	defer srv.Close()
+
+	c := NewConfig()
+	c.BaseURL = srv.URL + "/api"
+	h := mustNew(t, c)
+
+	_, err := h.NewRequest(http.MethodGet, "slow").Timeout(50 * time.Millisecond).Bytes()
+	assert.Error(t, err)
+
+	err = h.NewRequest(http.MethodGet, "slow").JSON(func() {}).DecodeJSON(nil)
+	assert.Error(t, err, "marshal error should be deferred")
+}
+
+func TestRequestURL(t *testing.T) {
+	c := NewConfig()
+	c.BaseURL = "http://api.local/v1"
+	h := mustNew(t, c)
+
+	u, err := h.NewRequest(http.MethodGet, "/redirect?to=http://other.local/").url()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://api.local/v1/redirect?to=http://other.local/", u, "'://' in the query should not skip BaseURL")
+
+	u, err = h.NewRequest(http.MethodGet, "/items/{id}").PathParam("id", "a:b").url()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://api.local/v1/items/a:b", u)
+
+	u, err = h.NewRequest(http.MethodGet, "https://other.local/x").url()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://other.local/x", u)
+}
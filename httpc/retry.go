@@ -0,0 +1,251 @@
+package httpc
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	drainBodySize = 4096
+)
+
+// retryTransport retries a request on retryable status codes and transport errors,
+// waiting an exponential backoff with jitter or the Retry-After of the response between attempts.
+type retryTransport struct {
+	h      *HttpC
+	config *RetryConfig
+	status map[int]struct{}
+	next   http.RoundTripper
+}
+
+func newRetryTransport(h *HttpC, c *RetryConfig, next http.RoundTripper) *retryTransport {
+	cc := *c
+	c = &cc
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if c.Multiplier <= 0 {
+		c.Multiplier = DefaultRetryMultiplier
+	}
+	if len(c.Status) == 0 {
+		c.Status = DefaultRetryStatus
+	}
+	if c.MaxRetryAfter <= 0 {
+		c.MaxRetryAfter = DefaultRetryMaxRetryAfter
+	}
+	if c.MaxReplayBodySize <= 0 {
+		c.MaxReplayBodySize = DefaultRetryMaxReplayBodySize
+	}
+
+	t := &retryTransport{
+		h:      h,
+		config: c,
+		status: make(map[int]struct{}, len(c.Status)),
+		next:   next,
+	}
+	for _, code := range c.Status {
+		t.status[code] = struct{}{}
+	}
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.config.MaxAttempts <= 1 || isStream(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	req, replayable, err := t.prepareBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		return t.next.RoundTrip(req)
+	}
+
+	idempotent := isIdempotent(req)
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.config.MaxAttempts || !t.shouldRetry(req, idempotent, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > t.config.MaxRetryAfter.ToDuration() {
+					return resp, nil
+				}
+				if retryAfter > wait {
+					wait = retryAfter
+				}
+			}
+			drainBody(resp.Body)
+		}
+
+		t.h.Debug("Retry request",
+			zap.String("method", req.Method),
+			zap.String("host", req.URL.Host),
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Int("status", statusOf(resp)),
+			zap.Error(err))
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// prepareBody makes sure the body can be read again for each attempt. A body without GetBody is
+// buffered in memory up to MaxReplayBodySize, a larger one is sent once without retrying.
+func (t *retryTransport) prepareBody(req *http.Request) (*http.Request, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, true, nil
+	}
+
+	body := req.Body
+	buf, err := ioutil.ReadAll(io.LimitReader(body, t.config.MaxReplayBodySize+1))
+	if err != nil {
+		_ = body.Close()
+		return nil, false, err
+	}
+
+	req = req.Clone(req.Context())
+	if int64(len(buf)) > t.config.MaxReplayBodySize {
+		req.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(buf), body), Closer: body}
+		return req, false, nil
+	}
+
+	_ = body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return req, true, nil
+}
+
+func (t *retryTransport) shouldRetry(req *http.Request, idempotent bool, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		var openErr *CircuitOpenError
+		// the request context is alive here, so a deadline exceeded comes from AttemptTimeout
+		if errors.Is(err, context.Canceled) || errors.Is(err, ErrClosed) || errors.Is(err, ErrLimitDeadline) ||
+			errors.As(err, &openErr) {
+			return false
+		}
+		return idempotent || isDialError(err)
+	}
+
+	if !idempotent {
+		return false
+	}
+	_, ok := t.status[resp.StatusCode]
+	return ok
+}
+
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := float64(t.config.InitialBackoff.ToDuration()) * math.Pow(t.config.Multiplier, float64(attempt-1))
+	if limit := float64(t.config.MaxBackoff.ToDuration()); d > limit {
+		d = limit
+	}
+	if t.config.Jitter > 0 {
+		d *= 1 - t.config.Jitter + rand.Float64()*2*t.config.Jitter
+	}
+	return time.Duration(d)
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// isIdempotent reports whether the request can be sent more than once,
+// either by method or by carrying an Idempotency-Key header.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// isDialError reports whether err happened before the request was written.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+func drainBody(body io.ReadCloser) {
+	_, _ = io.CopyN(ioutil.Discard, body, drainBodySize)
+	_ = body.Close()
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
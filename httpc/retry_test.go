@@ -0,0 +1,193 @@
+package httpc
+
+import (
+	"context"
+	"github.com/donkeywon/gtil/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRetryClient(t *testing.T) *HttpC {
+	c := NewConfig()
+	c.Retry = NewRetryConfig()
+	c.Retry.InitialBackoff = config.Duration(time.Millisecond)
+	return mustNew(t, c)
+}
+
+func flakyServer(fails int, status int, hits *atomic.Int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if hits.Inc() <= int64(fails) {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+}
+
+func TestRetryStatus(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := flakyServer(2, http.StatusServiceUnavailable, hits)
+	defer srv.Close()
+
+	h := newRetryClient(t)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(3), hits.Load())
+	assert.Equal(t, float64(1), h.Statistics()[StatRequests], "retries should not count as requests")
+	assert.Equal(t, float64(3), h.Statistics()[StatAttempts])
+}
+
+func TestRetryExhausted(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := flakyServer(10, http.StatusBadGateway, hits)
+	defer srv.Close()
+
+	h := newRetryClient(t)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int64(DefaultRetryMaxAttempts), hits.Load())
+}
+
+func TestRetryReplayBody(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := flakyServer(1, http.StatusServiceUnavailable, hits)
+	defer srv.Close()
+
+	h := newRetryClient(t)
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, ioutil.NopCloser(strings.NewReader("payload")))
+	resp, err := h.Do(req)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "payload", string(body))
+	assert.Equal(t, int64(2), hits.Load())
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := flakyServer(1, http.StatusServiceUnavailable, hits)
+	defer srv.Close()
+
+	h := newRetryClient(t)
+	resp, err := h.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int64(1), hits.Load())
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	req.Header.Set("Idempotency-Key", "1")
+	resp, err = h.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRetryDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	h := newRetryClient(t)
+	start := time.Now()
+	_, err = h.Post("http://"+addr, "text/plain", strings.NewReader("payload"))
+	assert.Error(t, err)
+	assert.True(t, isDialError(err))
+	assert.True(t, time.Since(start) >= time.Millisecond)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	d, ok := parseRetryAfter("2")
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, d)
+
+	d, ok = parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.True(t, d > 59*time.Minute)
+
+	_, ok = parseRetryAfter("soon")
+	assert.False(t, ok)
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Inc()
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	h := newRetryClient(t)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, int64(1), hits.Load())
+}
+
+func TestRetryStream(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := flakyServer(1, http.StatusServiceUnavailable, hits)
+	defer srv.Close()
+
+	h := newRetryClient(t)
+	req, _ := http.NewRequestWithContext(WithStream(context.Background()), http.MethodPut, srv.URL, strings.NewReader("payload"))
+	req.GetBody = nil
+	resp, err := h.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "streamed request should not be retried")
+	assert.Equal(t, int64(1), hits.Load())
+}
+
+func TestRetryZeroConfig(t *testing.T) {
+	hits := atomic.NewInt64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if hits.Inc() <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Retry = &RetryConfig{MaxAttempts: 3}
+	h := mustNew(t, c)
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, ioutil.NopCloser(strings.NewReader("payload")))
+	resp, err := h.Do(req)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "payload", string(body))
+	assert.Equal(t, int64(3), hits.Load())
+
+	rt := newRetryTransport(h, c.Retry, nil)
+	assert.Greater(t, int64(rt.backoff(2)), int64(rt.backoff(1)/2), "zero Multiplier should use the default")
+
+	hits.Store(0)
+	c.Retry = &RetryConfig{}
+	h = mustNew(t, c)
+	resp, err = h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "zero MaxAttempts should use the default")
+	assert.Equal(t, int64(DefaultRetryMaxAttempts), hits.Load())
+}
@@ -0,0 +1,137 @@
+package httpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+func newTransport(config *Config) (*http.Transport, error) {
+	dialer, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := newProxyFunc(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy:                  proxy,
+		DialContext:            dialer.DialContext,
+		TLSClientConfig:        tlsConfig,
+		TLSHandshakeTimeout:    config.TLSHandshakeTimeout.ToDuration(),
+		DisableKeepAlives:      config.DisableKeepAlives,
+		DisableCompression:     config.DisableCompression,
+		MaxIdleConns:           config.MaxIdleConns,
+		MaxIdleConnsPerHost:    config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        config.MaxConnsPerHost,
+		IdleConnTimeout:        config.IdleConnTimeout.ToDuration(),
+		ResponseHeaderTimeout:  config.ResponseHeaderTimeout.ToDuration(),
+		ExpectContinueTimeout:  config.ExpectContinueTimeout.ToDuration(),
+		MaxResponseHeaderBytes: config.MaxResponseHeaderBytes,
+		WriteBufferSize:        config.WriteBufferSize,
+		ReadBufferSize:         config.ReadBufferSize,
+		// a custom dialer or TLS config disables HTTP/2 unless forced
+		ForceAttemptHTTP2: true,
+	}, nil
+}
+
+func newDialer(config *Config) (*net.Dialer, error) {
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout.ToDuration(),
+		KeepAlive: config.KeepAlive.ToDuration(),
+	}
+
+	if config.LocalAddr != "" {
+		addr := config.LocalAddr
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "0")
+		}
+		local, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, ErrInvalidLocalAddr, config.LocalAddr)
+		}
+		dialer.LocalAddr = local
+	}
+
+	return dialer, nil
+}
+
+func newTLSConfig(c *TLSConfig) (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, ErrLoadCA, c.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf(ErrNoCACert, c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, ErrLoadCert, c.CertFile, c.KeyFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func newProxyFunc(c *ProxyConfig) (func(*http.Request) (*url.URL, error), error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.FromEnvironment {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	for _, p := range []string{c.HTTPProxy, c.HTTPSProxy} {
+		if p == "" {
+			continue
+		}
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, ErrInvalidProxy, p)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, errors.Errorf(ErrInvalidProxy, p)
+		}
+	}
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  c.HTTPProxy,
+		HTTPSProxy: c.HTTPSProxy,
+		NoProxy:    c.NoProxy,
+	}).ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
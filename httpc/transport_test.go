@@ -0,0 +1,127 @@
+package httpc
+
+import (
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.ServerName))
+	}))
+	defer srv.Close()
+
+	h := mustNew(t, NewConfig())
+	_, err := h.Get(srv.URL)
+	assert.Error(t, err, "unknown authority should fail")
+	assert.Equal(t, StatErrTLS, classifyError(err))
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, ca, 0600))
+
+	c := NewConfig()
+	c.TLS = &TLSConfig{CAFile: caFile, ServerName: "example.com"}
+	h = mustNew(t, c)
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "example.com", string(body))
+
+	c.TLS = &TLSConfig{InsecureSkipVerify: true}
+	h = mustNew(t, c)
+	resp, err = h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+}
+
+func TestTLSConfigError(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	assert.NoError(t, ioutil.WriteFile(empty, []byte("nothing"), 0600))
+
+	for _, tc := range []*TLSConfig{
+		{CAFile: filepath.Join(dir, "missing.pem")},
+		{CAFile: empty},
+		{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")},
+	} {
+		c := NewConfig()
+		c.TLS = tc
+		_, err := New(c)
+		assert.Error(t, err)
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("direct"))
+	}))
+	defer target.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("proxy " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	get := func(h *HttpC, u string) string {
+		resp, err := h.Get(u)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return string(body)
+	}
+
+	c := NewConfig()
+	c.Proxy = &ProxyConfig{HTTPProxy: proxy.URL}
+	h := mustNew(t, c)
+	// localhost is always excluded from proxying, so a fake domain is proxied instead
+	assert.Equal(t, "proxy http://upstream.test/path", get(h, "http://upstream.test/path"))
+
+	c.Proxy.NoProxy = "upstream.test"
+	h = mustNew(t, c)
+	_, err := h.Get("http://upstream.test/path")
+	assert.Error(t, err, "no proxy host should be resolved directly")
+
+	c.Proxy = &ProxyConfig{HTTPProxy: "ftp://proxy"}
+	_, err = New(c)
+	assert.Error(t, err)
+
+	c.Proxy = &ProxyConfig{FromEnvironment: true}
+	h = mustNew(t, c)
+	assert.Equal(t, "direct", get(h, target.URL))
+}
+
+func TestDialerConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.LocalAddr = "127.0.0.1"
+	c.MaxConnsPerHost = 1
+	h := mustNew(t, c)
+	assert.Equal(t, 1, h.transport.MaxConnsPerHost)
+
+	resp, err := h.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	c.LocalAddr = "not an ip"
+	_, err = New(c)
+	assert.Error(t, err)
+}
+
+func TestMain(m *testing.M) {
+	for _, env := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy"} {
+		_ = os.Unsetenv(env)
+	}
+	os.Exit(m.Run())
+}
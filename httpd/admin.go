@@ -0,0 +1,175 @@
+package httpd
+
+import (
+	"github.com/donkeywon/gtil/service"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+const (
+	AdminHandlerName = "admin"
+
+	serviceStatusOK     = "ok"
+	serviceStatusClosed = "closed"
+	serviceStatusError  = "error"
+)
+
+type ServiceInfo struct {
+	Name       string             `json:"name"`
+	Status     string             `json:"status"`
+	Error      string             `json:"error,omitempty"`
+	Statistics map[string]float64 `json:"statistics,omitempty"`
+	Children   []*ServiceInfo     `json:"children,omitempty"`
+}
+
+// NewAdminRouter creates a router with the admin endpoints, it is meant to be passed to
+// SetNamedHandler(AdminHandlerName, ...) and served by a dedicated listener.
+func NewAdminRouter(root service.Service, level zap.AtomicLevel) *mux.Router {
+	r := mux.NewRouter()
+	RegisterAdmin(r, root, level)
+	return r
+}
+
+// RegisterAdmin registers the admin endpoints on r, which may also be a PathPrefix subrouter:
+//
+//	/healthz      200 while root is not closed
+//	/readyz       200 while root is open and no service in the tree has an error
+//	/services     the service tree with status, error and statistics
+//	/statistics   the statistics of every service keyed by path
+//	/routes       the current routes of every HttpD keyed by path
+//	/loglevel     GET or PUT {"level":"debug"} on level
+//	/debug/pprof/ net/http/pprof
+func RegisterAdmin(r *mux.Router, root service.Service, level zap.AtomicLevel) {
+	r.HandleFunc("/healthz", healthzHandler(root)).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/readyz", readyzHandler(root)).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/services", servicesHandler(root)).Methods(http.MethodGet)
+	r.HandleFunc("/statistics", statisticsHandler(root)).Methods(http.MethodGet)
+	r.HandleFunc("/routes", routesHandler(root)).Methods(http.MethodGet)
+	r.Handle("/loglevel", level).Methods(http.MethodGet, http.MethodPut)
+
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprofIndex)
+}
+
+// pprofIndex strips any prefix before /debug/pprof/ as pprof.Index looks up profiles by the full path.
+func pprofIndex(w http.ResponseWriter, r *http.Request) {
+	if i := strings.Index(r.URL.Path, "/debug/pprof/"); i > 0 {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = r.URL.Path[i:]
+		r = r2
+	}
+	pprof.Index(w, r)
+}
+
+func healthzHandler(root service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isClosed(root) {
+			http.Error(w, serviceStatusClosed, http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(serviceStatusOK))
+	}
+}
+
+func readyzHandler(root service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := ServiceTree(root)
+		if root.Context() == nil || !isReady(info) {
+			WriteJSON(w, http.StatusServiceUnavailable, info)
+			return
+		}
+		_, _ = w.Write([]byte(serviceStatusOK))
+	}
+}
+
+func servicesHandler(root service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, ServiceTree(root))
+	}
+}
+
+func statisticsHandler(root service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := make(map[string]map[string]float64)
+		collectStatistics(stats, "", root)
+		WriteJSON(w, http.StatusOK, stats)
+	}
+}
+
+func routesHandler(root service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		routes := make(map[string][]*RouteInfo)
+		collectRoutes(routes, "", root)
+		WriteJSON(w, http.StatusOK, routes)
+	}
+}
+
+// ServiceTree returns the status of svc and all its descendants.
+func ServiceTree(svc service.Service) *ServiceInfo {
+	info := &ServiceInfo{
+		Name:       svc.Name(),
+		Status:     serviceStatusOK,
+		Statistics: svc.Statistics(),
+	}
+
+	if err := svc.LastError(); err != nil {
+		info.Status = serviceStatusError
+		info.Error = err.Error()
+	}
+	if isClosed(svc) {
+		info.Status = serviceStatusClosed
+	}
+
+	for _, child := range svc.ChildrenSvcs() {
+		info.Children = append(info.Children, ServiceTree(child))
+	}
+
+	return info
+}
+
+func isReady(info *ServiceInfo) bool {
+	if info.Status != serviceStatusOK {
+		return false
+	}
+	for _, child := range info.Children {
+		if !isReady(child) {
+			return false
+		}
+	}
+	return true
+}
+
+func isClosed(svc service.Service) bool {
+	select {
+	case <-svc.Closed():
+		return true
+	default:
+		return false
+	}
+}
+
+func collectStatistics(stats map[string]map[string]float64, prefix string, svc service.Service) {
+	path := prefix + "/" + svc.Name()
+	if s := svc.Statistics(); s != nil {
+		stats[path] = s
+	}
+	for _, child := range svc.ChildrenSvcs() {
+		collectStatistics(stats, path, child)
+	}
+}
+
+func collectRoutes(routes map[string][]*RouteInfo, prefix string, svc service.Service) {
+	path := prefix + "/" + svc.Name()
+	if rs, ok := svc.(interface{ Routes() []*RouteInfo }); ok {
+		routes[path] = rs.Routes()
+	}
+	for _, child := range svc.ChildrenSvcs() {
+		collectRoutes(routes, path, child)
+	}
+}
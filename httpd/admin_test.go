@@ -0,0 +1,59 @@
+package httpd
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/donkeywon/gtil/service"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRegisterAdmin(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+
+	c := NewConfig("")
+	admin := NewListenerConfig(NetworkTCP, "127.0.0.1:0")
+	admin.Handler = AdminHandlerName
+	c.Listeners = []*ListenerConfig{admin}
+
+	h := New(c)
+	h.SetNamedHandler(AdminHandlerName, NewAdminRouter(h, level))
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	base := "http://" + h.Addr().String()
+	assert.Equal(t, "ok", get(t, http.DefaultClient, base+"/healthz"))
+	assert.Equal(t, "ok", get(t, http.DefaultClient, base+"/readyz"))
+
+	info := &ServiceInfo{}
+	err = json.Unmarshal([]byte(get(t, http.DefaultClient, base+"/services")), info)
+	assert.NoError(t, err, "unmarshal service tree fail")
+	assert.Equal(t, Name, info.Name)
+	assert.Equal(t, "ok", info.Status)
+
+	req, _ := http.NewRequest(http.MethodPut, base+"/loglevel", strings.NewReader(`{"level":"debug"}`))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "put loglevel fail")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, zap.DebugLevel, level.Level())
+
+	resp, err = http.Get(base + "/debug/pprof/goroutine?debug=1")
+	assert.NoError(t, err, "get pprof fail")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServiceTree(t *testing.T) {
+	h := New(NewConfig("127.0.0.1:0"))
+	h.AppendError(http.ErrServerClosed)
+
+	info := ServiceTree(h)
+	assert.Equal(t, "error", info.Status)
+	assert.False(t, isReady(info))
+}
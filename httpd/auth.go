@@ -0,0 +1,310 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthMethodAPIKey = "apikey"
+	AuthMethodBasic  = "basic"
+	AuthMethodHMAC   = "hmac"
+	AuthMethodJWT    = "jwt"
+
+	HeaderAuthKeyID     = "X-Auth-Key-Id"
+	HeaderAuthTimestamp = "X-Auth-Timestamp"
+	HeaderAuthSignature = "X-Auth-Signature"
+
+	headerAuthorization   = "Authorization"
+	headerWWWAuthenticate = "WWW-Authenticate"
+)
+
+// dummyHash is compared against for unknown users, so they take as long as wrong passwords.
+var dummyHash = []byte("$2a$10$YC2Kv5IljmS7eiLXEgsPEusFYUv88/NelWaKpoOIymYdaUrUa6WVm")
+
+var (
+	ErrUnauthenticated   = errors.New("Unauthenticated")
+	ErrInvalidCredential = errors.New("Invalid credential")
+	ErrSignatureExpired  = errors.New("Signature timestamp out of allowed skew")
+)
+
+type principalKey struct{}
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	Name   string
+	Method string
+	// Claims are the JWT claims, nil for other methods.
+	Claims map[string]interface{}
+}
+
+// Authenticator returns nil, nil if the request carries no credential it understands,
+// so the next Authenticator is tried, and an error if the credential is invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge is the WWW-Authenticate value sent on 401, may be empty.
+	Challenge() string
+}
+
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// AuthMiddleware rejects requests no authenticator accepts with 401, accepted requests
+// carry the Principal in their context. Use it with mux.Router.Use.
+func (s *HttpD) AuthMiddleware(authenticators ...Authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := ErrUnauthenticated
+			for _, a := range authenticators {
+				p, aerr := a.Authenticate(r)
+				if aerr != nil {
+					err = aerr
+					break
+				}
+				if p != nil {
+					next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+					return
+				}
+			}
+
+			for _, a := range authenticators {
+				if c := a.Challenge(); c != "" {
+					w.Header().Add(headerWWWAuthenticate, c)
+				}
+			}
+			var e *Error
+			if !errors.As(err, &e) {
+				e = &Error{Status: http.StatusUnauthorized, Err: err}
+			}
+			s.WriteError(w, r, e)
+		})
+	}
+}
+
+// NewAuthenticators creates the authenticators enabled in c, in the order api key, basic, hmac, jwt.
+func NewAuthenticators(c *AuthConfig) ([]Authenticator, error) {
+	var as []Authenticator
+	if len(c.APIKeys) > 0 {
+		as = append(as, NewAPIKeyAuthenticator(c.APIKeyHeader, c.APIKeys))
+	}
+	if len(c.BasicUsers) > 0 {
+		as = append(as, NewBasicAuthenticator(c.BasicRealm, c.BasicUsers))
+	}
+	if len(c.HMACKeys) > 0 {
+		as = append(as, NewHMACAuthenticator(c.HMACKeys, c.HMACMaxSkew.ToDuration(), c.HMACMaxBodySize))
+	}
+	if c.JWKSFile != "" {
+		a, err := NewJWTAuthenticator(c.JWKSFile, c.JWTIssuer, c.JWTAudience, c.JWTLeeway.ToDuration())
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+	return as, nil
+}
+
+type apiKeyAuthenticator struct {
+	header string
+	keys   map[string]string
+}
+
+// NewAPIKeyAuthenticator accepts requests whose header carries one of keys, which maps principal name to key.
+func NewAPIKeyAuthenticator(header string, keys map[string]string) Authenticator {
+	return &apiKeyAuthenticator{
+		header: header,
+		keys:   keys,
+	}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(a.header)
+	if key == "" {
+		return nil, nil
+	}
+	for name, k := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return &Principal{Name: name, Method: AuthMethodAPIKey}, nil
+		}
+	}
+	return nil, ErrInvalidCredential
+}
+
+func (a *apiKeyAuthenticator) Challenge() string {
+	return ""
+}
+
+type basicAuthenticator struct {
+	realm string
+	users map[string]string
+	// verified caches MACs of credentials bcrypt already accepted, as bcrypt is slow by design,
+	// cacheKey is random so the cache can not be used to brute force passwords
+	verified sync.Map
+	cacheKey []byte
+}
+
+// NewBasicAuthenticator accepts HTTP Basic credentials, users maps user name to bcrypt hash.
+func NewBasicAuthenticator(realm string, users map[string]string) Authenticator {
+	cacheKey := make([]byte, 32)
+	_, _ = rand.Read(cacheKey)
+	return &basicAuthenticator{
+		realm:    realm,
+		users:    users,
+		cacheKey: cacheKey,
+	}
+}
+
+func (a *basicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	hash, ok := a.users[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return nil, ErrInvalidCredential
+	}
+
+	mac := hmac.New(sha256.New, a.cacheKey)
+	mac.Write([]byte(user + "\x00" + pass + "\x00" + hash))
+	sum := string(mac.Sum(nil))
+	if _, ok = a.verified.Load(sum); !ok {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+			return nil, ErrInvalidCredential
+		}
+		a.verified.Store(sum, struct{}{})
+	}
+
+	return &Principal{Name: user, Method: AuthMethodBasic}, nil
+}
+
+func (a *basicAuthenticator) Challenge() string {
+	return `Basic realm="` + a.realm + `", charset="UTF-8"`
+}
+
+type hmacAuthenticator struct {
+	keys        map[string]string
+	maxSkew     time.Duration
+	maxBodySize int64
+}
+
+// NewHMACAuthenticator accepts requests signed by SignRequest with one of keys, which maps key id to secret.
+// The signed timestamp must be within maxSkew of now, maxBodySize <= 0 uses DefaultAuthHMACMaxBodySize.
+func NewHMACAuthenticator(keys map[string]string, maxSkew time.Duration, maxBodySize int64) Authenticator {
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultAuthHMACMaxBodySize
+	}
+	return &hmacAuthenticator{
+		keys:        keys,
+		maxSkew:     maxSkew,
+		maxBodySize: maxBodySize,
+	}
+}
+
+func (a *hmacAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(HeaderAuthKeyID)
+	if keyID == "" {
+		return nil, nil
+	}
+
+	secret, ok := a.keys[keyID]
+	if !ok {
+		return nil, ErrInvalidCredential
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(HeaderAuthTimestamp), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, ErrSignatureExpired
+	}
+
+	body, err := a.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := signature(secret, r.Method, r.URL.RequestURI(), r.Header.Get(HeaderAuthTimestamp), body)
+	actual, err := hex.DecodeString(r.Header.Get(HeaderAuthSignature))
+	if err != nil || !hmac.Equal(expected, actual) {
+		return nil, ErrInvalidCredential
+	}
+
+	return &Principal{Name: keyID, Method: AuthMethodHMAC}, nil
+}
+
+func (a *hmacAuthenticator) Challenge() string {
+	return ""
+}
+
+// readBody reads the body for signing and puts it back for the handler.
+func (a *hmacAuthenticator) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(&limitedBody{ReadCloser: r.Body, remain: a.maxBodySize, err: ErrBodyTooLarge})
+	if errors.Is(err, ErrBodyTooLarge) {
+		return nil, NewError(http.StatusRequestEntityTooLarge, err)
+	}
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, err)
+	}
+	_ = r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// SignRequest signs req for NewHMACAuthenticator, the signature covers method, request uri,
+// timestamp and body. The body of req is read and replaced.
+func SignRequest(req *http.Request, keyID string, secret string) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		_ = req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderAuthKeyID, keyID)
+	req.Header.Set(HeaderAuthTimestamp, ts)
+	req.Header.Set(HeaderAuthSignature, hex.EncodeToString(signature(secret, req.Method, req.URL.RequestURI(), ts, body)))
+	return nil
+}
+
+func signature(secret string, method string, uri string, ts string, body []byte) []byte {
+	bodySum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{method, uri, ts, hex.EncodeToString(bodySum[:])}, "\n")))
+	return mac.Sum(nil)
+}
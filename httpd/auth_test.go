@@ -0,0 +1,157 @@
+package httpd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(bs []byte) string {
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.NoError(t, err, "sign jwt fail")
+	return signed + "." + b64(sig)
+}
+
+func newAuthTestRouter(t *testing.T) (*mux.Router, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "generate key fail")
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"n":   b64(key.N.Bytes()),
+		"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, ioutil.WriteFile(jwksFile, jwks, 0600))
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+
+	c := NewAuthConfig()
+	c.APIKeys = map[string]string{"svc": "key1"}
+	c.BasicUsers = map[string]string{"alice": string(hash)}
+	c.HMACKeys = map[string]string{"partner": "secret"}
+	c.JWKSFile = jwksFile
+	c.JWTIssuer = "gtil"
+
+	as, err := NewAuthenticators(c)
+	assert.NoError(t, err, "new authenticators fail")
+
+	h := New(NewConfig("127.0.0.1:0"))
+	r := mux.NewRouter()
+	r.Use(h.AuthMiddleware(as...))
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		p := PrincipalFromContext(r.Context())
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write([]byte(p.Method + ":" + p.Name + ":" + string(body)))
+	})
+	return r, key
+}
+
+func serveAuth(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHttpD_AuthMiddleware(t *testing.T) {
+	r, key := newAuthTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := serveAuth(r, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, []string{`Basic realm="Restricted", charset="UTF-8"`, "Bearer"}, w.Header()["Www-Authenticate"])
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", "key1")
+	assert.Equal(t, "apikey:svc:", serveAuth(r, req).Body.String())
+
+	req.Header.Set("X-API-Key", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(r, req).Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("alice", "pass")
+	assert.Equal(t, "basic:alice:", serveAuth(r, req).Body.String())
+	assert.Equal(t, "basic:alice:", serveAuth(r, req).Body.String(), "cached credential")
+	req.SetBasicAuth("alice", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(r, req).Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/?a=1", strings.NewReader("payload"))
+	assert.NoError(t, SignRequest(req, "partner", "secret"))
+	assert.Equal(t, "hmac:partner:payload", serveAuth(r, req).Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/?a=2", strings.NewReader("payload"))
+	assert.NoError(t, SignRequest(req, "partner", "secret"))
+	req.URL.RawQuery = "a=3"
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(r, req).Code, "tampered request")
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.NoError(t, SignRequest(req, "partner", "secret"))
+	req.Header.Set(HeaderAuthTimestamp, "1")
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(r, req).Code, "expired timestamp")
+
+	token := signJWT(t, key, "k1", map[string]interface{}{"sub": "bob", "iss": "gtil", "exp": time.Now().Add(time.Hour).Unix()})
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	assert.Equal(t, "jwt:bob:", serveAuth(r, req).Body.String())
+
+	token = signJWT(t, key, "k1", map[string]interface{}{"sub": "bob", "iss": "gtil", "exp": time.Now().Add(-time.Hour).Unix()})
+	req.Header.Set("Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(r, req).Code, "expired token")
+
+	token = signJWT(t, key, "k1", map[string]interface{}{"sub": "bob", "iss": "other"})
+	req.Header.Set("Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusUnauthorized, serveAuth(r, req).Code, "wrong issuer")
+}
+
+func TestJWTAuthenticator_AlgMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "generate key fail")
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"alg": "PS256",
+		"n":   b64(key.N.Bytes()),
+		"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	keys, err := parseJWKS(jwks)
+	assert.NoError(t, err, "parse jwks fail")
+
+	a := &jwtAuthenticator{keys: keys}
+	_, err = a.verify(signJWT(t, key, "k1", map[string]interface{}{"sub": "bob"}))
+	assert.ErrorIs(t, err, ErrInvalidToken, "RS256 token should not verify with a PS256 key")
+}
+
+func TestHMACAuthenticator_ZeroMaxBodySize(t *testing.T) {
+	a := NewHMACAuthenticator(map[string]string{"partner": "secret"}, time.Minute, 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload"))
+	assert.NoError(t, SignRequest(req, "partner", "secret"))
+	p, err := a.Authenticate(req)
+	assert.NoError(t, err, "zero max body size should use the default")
+	assert.Equal(t, "partner", p.Name)
+}
@@ -0,0 +1,40 @@
+package httpd
+
+import (
+	"io"
+)
+
+// limitedBody fails with err once more than remain bytes are read, unlike io.LimitReader
+// which silently truncates.
+type limitedBody struct {
+	io.ReadCloser
+	// orig is closed together with ReadCloser when it wraps another body, may be nil.
+	orig   io.Closer
+	remain int64
+	err    error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remain <= 0 {
+		// read one more byte to tell exact limit from overflow
+		n, err := b.ReadCloser.Read(make([]byte, 1))
+		if n > 0 {
+			return 0, b.err
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remain -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.orig != nil {
+		err = b.orig.Close()
+	}
+	return err
+}
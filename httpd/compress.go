@@ -0,0 +1,323 @@
+package httpd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+
+	headerAcceptEncoding  = "Accept-Encoding"
+	headerContentEncoding = "Content-Encoding"
+	headerContentLength   = "Content-Length"
+	headerContentType     = "Content-Type"
+	headerVary            = "Vary"
+)
+
+var ErrDecompressedTooLarge = errors.New("Decompressed request body too large")
+
+type compressHandler struct {
+	next   http.Handler
+	config *CompressionConfig
+
+	level               int
+	contentTypes        []string
+	maxDecompressedSize int64
+
+	gzipPool sync.Pool
+	zlibPool sync.Pool
+}
+
+func newCompressHandler(next http.Handler, c *CompressionConfig) *compressHandler {
+	h := &compressHandler{
+		next:                next,
+		config:              c,
+		level:               c.Level,
+		contentTypes:        c.ContentTypes,
+		maxDecompressedSize: c.MaxDecompressedSize,
+	}
+	// 0 is gzip.NoCompression, which would mark responses as encoded without compressing them
+	if h.level == 0 {
+		h.level = DefaultCompressionLevel
+	}
+	if len(h.contentTypes) == 0 {
+		h.contentTypes = DefaultCompressionContentTypes
+	}
+	if h.maxDecompressedSize <= 0 {
+		h.maxDecompressedSize = DefaultCompressionMaxDecompressedSize
+	}
+
+	h.gzipPool.New = func() interface{} {
+		w, err := gzip.NewWriterLevel(nil, h.level)
+		if err != nil {
+			w = gzip.NewWriter(nil)
+		}
+		return w
+	}
+	h.zlibPool.New = func() interface{} {
+		w, err := zlib.NewWriterLevel(nil, h.level)
+		if err != nil {
+			w = zlib.NewWriter(nil)
+		}
+		return w
+	}
+	return h
+}
+
+func (h *compressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.config.DecompressRequest && r.Body != nil && r.Header.Get(headerContentEncoding) != "" {
+		if !h.decompressRequest(w, r) {
+			return
+		}
+	}
+
+	encoding := negotiateEncoding(r.Header.Get(headerAcceptEncoding))
+	if encoding == "" || r.Method == http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	cw := &compressWriter{
+		ResponseWriter: w,
+		handler:        h,
+		encoding:       encoding,
+		status:         http.StatusOK,
+	}
+	defer cw.close()
+
+	h.next.ServeHTTP(cw, r)
+}
+
+// decompressRequest replaces the body of a compressed request, it responds and returns false
+// when the encoding is unsupported or the body is not valid.
+func (h *compressHandler) decompressRequest(w http.ResponseWriter, r *http.Request) bool {
+	var (
+		body io.ReadCloser
+		err  error
+	)
+
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get(headerContentEncoding))) {
+	case EncodingGzip:
+		body, err = gzip.NewReader(r.Body)
+	case EncodingDeflate:
+		body, err = zlib.NewReader(r.Body)
+	default:
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return false
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return false
+	}
+
+	r.Body = &limitedBody{ReadCloser: body, orig: r.Body, remain: h.maxDecompressedSize, err: ErrDecompressedTooLarge}
+	r.Header.Del(headerContentEncoding)
+	r.Header.Del(headerContentLength)
+	r.ContentLength = -1
+	return true
+}
+
+// negotiateEncoding returns the preferred supported encoding of an Accept-Encoding header, gzip wins ties.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+
+		if coding == "*" {
+			coding = EncodingGzip
+		}
+		if coding != EncodingGzip && coding != EncodingDeflate || q <= 0 {
+			continue
+		}
+		if q > bestQ || q == bestQ && coding == EncodingGzip {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the response until MinSize bytes are written, then decides by
+// content type whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	handler  *compressHandler
+	encoding string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	cw          io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+
+	if !bodyAllowed(status) || w.Header().Get(headerContentEncoding) != "" || status == http.StatusPartialContent {
+		w.decided = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.cw != nil {
+			return w.cw.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.handler.config.MinSize {
+		err := w.decide(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+
+	h := w.Header()
+	if h.Get(headerContentType) == "" && len(w.buf) > 0 {
+		h.Set(headerContentType, http.DetectContentType(w.buf))
+	}
+	h.Add(headerVary, headerAcceptEncoding)
+
+	if large && h.Get(headerContentEncoding) == "" && w.handler.compressible(h.Get(headerContentType)) {
+		h.Set(headerContentEncoding, w.encoding)
+		h.Del(headerContentLength)
+		if w.encoding == EncodingGzip {
+			gw := w.handler.gzipPool.Get().(*gzip.Writer)
+			gw.Reset(w.ResponseWriter)
+			w.cw = gw
+		} else {
+			zw := w.handler.zlibPool.Get().(*zlib.Writer)
+			zw.Reset(w.ResponseWriter)
+			w.cw = zw
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.cw != nil {
+		_, err = w.cw.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		if !w.wroteHeader {
+			// nothing written, let net/http send the default response
+			return
+		}
+		_ = w.decide(false)
+	}
+	if w.cw == nil {
+		return
+	}
+
+	_ = w.cw.Close()
+	switch cw := w.cw.(type) {
+	case *gzip.Writer:
+		cw.Reset(io.Discard)
+		w.handler.gzipPool.Put(cw)
+	case *zlib.Writer:
+		cw.Reset(io.Discard)
+		w.handler.zlibPool.Put(cw)
+	}
+	w.cw = nil
+}
+
+// Flush sends the buffered response, compressing it if the content type allows, so streaming
+// handlers are not held back by MinSize.
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		_ = w.decide(true)
+	}
+	if f, ok := w.cw.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter does not implement http.Hijacker")
+	}
+	w.decided = true
+	w.wroteHeader = true
+	return hj.Hijack()
+}
+
+func (h *compressHandler) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	// events are flushed one by one, compressing them would hold them in the compressor
+	if err != nil || mediaType == ContentTypeEventStream {
+		return false
+	}
+
+	for _, allowed := range h.contentTypes {
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, allowed[:len(allowed)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,127 @@
+package httpd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newCompressTestHandler(body string, contentType string) http.Handler {
+	c := NewConfig("127.0.0.1:0")
+	c.Compression = NewCompressionConfig()
+	c.Compression.DecompressRequest = true
+	c.Compression.MaxDecompressedSize = 8
+
+	return New(c).wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			bs, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			body = string(bs)
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func TestCompressHandler_Response(t *testing.T) {
+	large := strings.Repeat(`{"a":"b"}`, 200)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate;q=0.5, gzip")
+	w := httptest.NewRecorder()
+	newCompressTestHandler(large, "application/json; charset=utf-8").ServeHTTP(w, req)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err, "new gzip reader fail")
+	bs, _ := ioutil.ReadAll(gr)
+	assert.Equal(t, large, string(bs))
+
+	req.Header.Set("Accept-Encoding", "gzip;q=0, deflate")
+	w = httptest.NewRecorder()
+	newCompressTestHandler(large, "text/plain").ServeHTTP(w, req)
+	assert.Equal(t, "deflate", w.Header().Get("Content-Encoding"))
+	zr, err := zlib.NewReader(w.Body)
+	assert.NoError(t, err, "new zlib reader fail")
+	bs, _ = ioutil.ReadAll(zr)
+	assert.Equal(t, large, string(bs))
+
+	w = httptest.NewRecorder()
+	newCompressTestHandler(large, "image/png").ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get("Content-Encoding"), "image/png should not be compressed")
+
+	w = httptest.NewRecorder()
+	newCompressTestHandler("small", "text/plain").ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get("Content-Encoding"), "small body should not be compressed")
+	assert.Equal(t, "small", w.Body.String())
+}
+
+func TestCompressHandler_Request(t *testing.T) {
+	compress := func(s string) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		_, _ = gw.Write([]byte(s))
+		_ = gw.Close()
+		return buf
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", compress("12345678"))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	newCompressTestHandler("", "text/plain").ServeHTTP(w, req)
+	assert.Equal(t, "12345678", w.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/", compress("123456789"))
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	newCompressTestHandler("", "text/plain").ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	newCompressTestHandler("", "text/plain").ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCompressHandler_ZeroConfig(t *testing.T) {
+	large := strings.Repeat("text ", 1000)
+	h := newCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(append(bs, large...))
+	}), &CompressionConfig{DecompressRequest: true})
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	_, _ = gw.Write([]byte("body "))
+	_ = gw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, "zero MaxDecompressedSize should use the default")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.True(t, w.Body.Len() < len(large)/10, "zero Level should compress with the default level")
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err, "new gzip reader fail")
+	bs, _ := ioutil.ReadAll(gr)
+	assert.Equal(t, "body "+large, string(bs))
+}
@@ -1,7 +1,9 @@
 package httpd
 
 import (
+	"compress/gzip"
 	"github.com/donkeywon/gtil/config"
+	"net/http"
 	"time"
 )
 
@@ -11,6 +13,71 @@ const (
 	DefaultReadHeaderTimeout = config.Duration(1000 * time.Millisecond)
 	DefaultIdleTimeout       = config.Duration(1000 * time.Millisecond)
 	DefaultMonitorInterval   = config.Duration(10 * time.Second)
+
+	DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+
+	DefaultHTTP2MaxConcurrentStreams = 250
+	DefaultHTTP2MaxReadFrameSize     = 1 << 20
+	DefaultHTTP2IdleTimeout          = config.Duration(0)
+
+	DefaultMinUploadRateGrace = config.Duration(5 * time.Second)
+
+	DefaultNetwork      = NetworkTCP
+	DefaultUnixFileMode = "0660"
+
+	DefaultCompressionMinSize             = 1024
+	DefaultCompressionLevel               = gzip.DefaultCompression
+	DefaultCompressionMaxDecompressedSize = 10 << 20
+
+	DefaultMaxJSONBodySize = 1 << 20
+
+	DefaultStaticIndex         = "index.html"
+	DefaultStaticPrecompressed = true
+
+	DefaultSSEHeartbeatInterval = config.Duration(15 * time.Second)
+	DefaultSSEReplaySize        = 100
+
+	DefaultWebSocketMaxMessageSize   = 1 << 20
+	DefaultWebSocketWriteQueueSize   = 64
+	DefaultWebSocketPingInterval     = config.Duration(30 * time.Second)
+	DefaultWebSocketPongTimeout      = config.Duration(60 * time.Second)
+	DefaultWebSocketWriteTimeout     = config.Duration(10 * time.Second)
+	DefaultWebSocketHandshakeTimeout = config.Duration(10 * time.Second)
+
+	DefaultAuthAPIKeyHeader    = "X-API-Key"
+	DefaultAuthBasicRealm      = "Restricted"
+	DefaultAuthHMACMaxSkew     = config.Duration(5 * time.Minute)
+	DefaultAuthHMACMaxBodySize = 10 << 20
+	DefaultAuthJWTLeeway       = config.Duration(time.Minute)
+
+	DefaultProxyBalance       = BalanceRoundRobin
+	DefaultProxyMaxFails      = 3
+	DefaultProxyEjectDuration = config.Duration(30 * time.Second)
+
+	DefaultCORSMaxAge = config.Duration(10 * time.Minute)
+
+	DefaultSecurityContentTypeNosniff = true
+	DefaultSecurityFrameOptions       = "DENY"
+	DefaultSecurityReferrerPolicy     = "no-referrer"
+)
+
+var (
+	DefaultCompressionContentTypes = []string{
+		"text/*",
+		"application/json",
+		"application/problem+json",
+		"application/javascript",
+		"application/xml",
+		"image/svg+xml",
+	}
+	DefaultCORSAllowedMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+	}
 )
 
 type Config struct {
@@ -20,15 +87,303 @@ type Config struct {
 	ReadHeaderTimeout config.Duration `yaml:"ReadHeaderTimeout,omitempty" mapstructure:"ReadHeaderTimeout,omitempty" json:"readHeaderTimeout,omitempty"`
 	IdleTimeout       config.Duration `yaml:"IdleTimeout,omitempty" mapstructure:"IdleTimeout,omitempty" json:"idleTimeout,omitempty"`
 	MonitorInterval   config.Duration `yaml:"MonitorInterval,omitempty" mapstructure:"MonitorInterval,omitempty" json:"monitorInterval,omitempty"`
+	// MaxHeaderBytes is enforced by http.Server, its 431 responses are not counted in StatHeaderCountExceeded.
+	MaxHeaderBytes int `yaml:"maxHeaderBytes,omitempty" mapstructure:"maxHeaderBytes,omitempty" json:"maxHeaderBytes,omitempty"`
+	// MaxHeaderCount is the max number of header values, 0 means unlimited.
+	MaxHeaderCount  int   `yaml:"maxHeaderCount,omitempty" mapstructure:"maxHeaderCount,omitempty" json:"maxHeaderCount,omitempty"`
+	MaxJSONBodySize int64 `yaml:"maxJSONBodySize,omitempty" mapstructure:"maxJSONBodySize,omitempty" json:"maxJSONBodySize,omitempty"`
+	// MaxBodySize is the max request body size of paths not matching BodyLimits, 0 means unlimited.
+	MaxBodySize int64              `yaml:"maxBodySize,omitempty" mapstructure:"maxBodySize,omitempty" json:"maxBodySize,omitempty"`
+	BodyLimits  []*BodyLimitConfig `yaml:"bodyLimits,omitempty" mapstructure:"bodyLimits,omitempty" json:"bodyLimits,omitempty"`
+	// MinUploadRate is the min average bytes per second of request bodies after MinUploadRateGrace, 0 means unlimited.
+	MinUploadRate      int64           `yaml:"minUploadRate,omitempty" mapstructure:"minUploadRate,omitempty" json:"minUploadRate,omitempty"`
+	MinUploadRateGrace config.Duration `yaml:"minUploadRateGrace,omitempty" mapstructure:"minUploadRateGrace,omitempty" json:"minUploadRateGrace,omitempty"`
+
+	Listeners []*ListenerConfig `yaml:"listeners,omitempty" mapstructure:"listeners,omitempty" json:"listeners,omitempty"`
+	// HTTP2 is nil to use the defaults of net/http, which only speaks HTTP/2 over TLS.
+	HTTP2  *HTTP2Config   `yaml:"http2,omitempty" mapstructure:"http2,omitempty" json:"http2,omitempty"`
+	Limits []*LimitConfig `yaml:"limits,omitempty" mapstructure:"limits,omitempty" json:"limits,omitempty"`
+	// Compression is nil to disable compression.
+	Compression *CompressionConfig `yaml:"compression,omitempty" mapstructure:"compression,omitempty" json:"compression,omitempty"`
+	// CORS is nil to disable CORS handling.
+	CORS *CORSConfig `yaml:"cors,omitempty" mapstructure:"cors,omitempty" json:"cors,omitempty"`
+	// SecurityHeaders is nil to send no security header.
+	SecurityHeaders *SecurityHeadersConfig `yaml:"securityHeaders,omitempty" mapstructure:"securityHeaders,omitempty" json:"securityHeaders,omitempty"`
+}
+
+// ListenerConfig describes one socket served by HttpD.
+// Zero timeouts inherit the value from Config, negative ones disable the timeout.
+type ListenerConfig struct {
+	// Network is one of tcp, tcp4, tcp6, unix or fd.
+	Network string `yaml:"network" mapstructure:"network" json:"network"`
+	// Addr is host:port for tcp, the socket path for unix,
+	// and the fd number or the LISTEN_FDNAMES name for fd.
+	Addr string `yaml:"addr" mapstructure:"addr" json:"addr"`
+	// Handler is the name passed to SetNamedHandler, empty means the one passed to SetHandler.
+	Handler string `yaml:"handler,omitempty" mapstructure:"handler,omitempty" json:"handler,omitempty"`
+
+	// FileMode is the octal permission of the unix socket file.
+	FileMode string `yaml:"fileMode,omitempty" mapstructure:"fileMode,omitempty" json:"fileMode,omitempty"`
+	// Owner is user[:group] of the unix socket file, names or ids.
+	Owner string `yaml:"owner,omitempty" mapstructure:"owner,omitempty" json:"owner,omitempty"`
+
+	WriteTimeout      config.Duration `yaml:"writeTimeout,omitempty" mapstructure:"writeTimeout,omitempty" json:"writeTimeout,omitempty"`
+	ReadTimeout       config.Duration `yaml:"readTimeout,omitempty" mapstructure:"readTimeout,omitempty" json:"readTimeout,omitempty"`
+	ReadHeaderTimeout config.Duration `yaml:"readHeaderTimeout,omitempty" mapstructure:"readHeaderTimeout,omitempty" json:"readHeaderTimeout,omitempty"`
+	IdleTimeout       config.Duration `yaml:"idleTimeout,omitempty" mapstructure:"idleTimeout,omitempty" json:"idleTimeout,omitempty"`
+}
+
+// BodyLimitConfig overrides Config.MaxBodySize for paths starting with PathPrefix, the first match applies.
+type BodyLimitConfig struct {
+	PathPrefix  string `yaml:"pathPrefix" mapstructure:"pathPrefix" json:"pathPrefix"`
+	MaxBodySize int64  `yaml:"maxBodySize" mapstructure:"maxBodySize" json:"maxBodySize"`
+}
+
+// HTTP2Config tunes HTTP/2, H2C enables HTTP/2 without TLS by prior knowledge or Upgrade: h2c.
+// IdleTimeout zero means Config.IdleTimeout.
+type HTTP2Config struct {
+	H2C                  bool            `yaml:"h2c,omitempty" mapstructure:"h2c,omitempty" json:"h2c,omitempty"`
+	MaxConcurrentStreams uint32          `yaml:"maxConcurrentStreams,omitempty" mapstructure:"maxConcurrentStreams,omitempty" json:"maxConcurrentStreams,omitempty"`
+	MaxReadFrameSize     uint32          `yaml:"maxReadFrameSize,omitempty" mapstructure:"maxReadFrameSize,omitempty" json:"maxReadFrameSize,omitempty"`
+	IdleTimeout          config.Duration `yaml:"idleTimeout,omitempty" mapstructure:"idleTimeout,omitempty" json:"idleTimeout,omitempty"`
+}
+
+// LimitConfig limits requests whose path starts with PathPrefix, every matching LimitConfig applies.
+// Requests over Rate get 429, requests not getting one of MaxInFlight slots within QueueTimeout get 503.
+type LimitConfig struct {
+	PathPrefix string `yaml:"pathPrefix,omitempty" mapstructure:"pathPrefix,omitempty" json:"pathPrefix,omitempty"`
+	// Rate is requests per second, 0 means unlimited.
+	Rate  float64 `yaml:"rate,omitempty" mapstructure:"rate,omitempty" json:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty" mapstructure:"burst,omitempty" json:"burst,omitempty"`
+	// KeyBy is empty for one bucket shared by all clients, ip for a bucket per client ip,
+	// or header:<Name> for a bucket per value of the header.
+	KeyBy string `yaml:"keyBy,omitempty" mapstructure:"keyBy,omitempty" json:"keyBy,omitempty"`
+	// MaxInFlight is the max concurrent requests, 0 means unlimited.
+	MaxInFlight  int             `yaml:"maxInFlight,omitempty" mapstructure:"maxInFlight,omitempty" json:"maxInFlight,omitempty"`
+	QueueTimeout config.Duration `yaml:"queueTimeout,omitempty" mapstructure:"queueTimeout,omitempty" json:"queueTimeout,omitempty"`
+}
+
+// CompressionConfig compresses responses with gzip or deflate by Accept-Encoding when the body has
+// at least MinSize bytes and its media type matches ContentTypes, which may contain type/* wildcards.
+// text/event-stream is never compressed, so events are not held back.
+// Zero Level, empty ContentTypes and zero MaxDecompressedSize use the defaults.
+type CompressionConfig struct {
+	MinSize      int      `yaml:"minSize,omitempty" mapstructure:"minSize,omitempty" json:"minSize,omitempty"`
+	Level        int      `yaml:"level,omitempty" mapstructure:"level,omitempty" json:"level,omitempty"`
+	ContentTypes []string `yaml:"contentTypes,omitempty" mapstructure:"contentTypes,omitempty" json:"contentTypes,omitempty"`
+	// DecompressRequest decodes gzip or deflate request bodies, reading more than
+	// MaxDecompressedSize bytes from them fails with ErrDecompressedTooLarge.
+	DecompressRequest   bool  `yaml:"decompressRequest,omitempty" mapstructure:"decompressRequest,omitempty" json:"decompressRequest,omitempty"`
+	MaxDecompressedSize int64 `yaml:"maxDecompressedSize,omitempty" mapstructure:"maxDecompressedSize,omitempty" json:"maxDecompressedSize,omitempty"`
+}
+
+// SSEConfig configures an SSEHub, Retry is sent to clients as reconnection delay if positive.
+type SSEConfig struct {
+	HeartbeatInterval config.Duration `yaml:"heartbeatInterval,omitempty" mapstructure:"heartbeatInterval,omitempty" json:"heartbeatInterval,omitempty"`
+	ReplaySize        int             `yaml:"replaySize,omitempty" mapstructure:"replaySize,omitempty" json:"replaySize,omitempty"`
+	Retry             config.Duration `yaml:"retry,omitempty" mapstructure:"retry,omitempty" json:"retry,omitempty"`
+}
+
+// WebSocketConfig configures HttpD.WebSocketHandler. The connection is closed if no pong arrives within
+// PongTimeout, AllowedOrigins may contain wildcards like CORSConfig and is same origin only if empty.
+type WebSocketConfig struct {
+	MaxMessageSize    int64           `yaml:"maxMessageSize,omitempty" mapstructure:"maxMessageSize,omitempty" json:"maxMessageSize,omitempty"`
+	WriteQueueSize    int             `yaml:"writeQueueSize,omitempty" mapstructure:"writeQueueSize,omitempty" json:"writeQueueSize,omitempty"`
+	PingInterval      config.Duration `yaml:"pingInterval,omitempty" mapstructure:"pingInterval,omitempty" json:"pingInterval,omitempty"`
+	PongTimeout       config.Duration `yaml:"pongTimeout,omitempty" mapstructure:"pongTimeout,omitempty" json:"pongTimeout,omitempty"`
+	WriteTimeout      config.Duration `yaml:"writeTimeout,omitempty" mapstructure:"writeTimeout,omitempty" json:"writeTimeout,omitempty"`
+	HandshakeTimeout  config.Duration `yaml:"handshakeTimeout,omitempty" mapstructure:"handshakeTimeout,omitempty" json:"handshakeTimeout,omitempty"`
+	EnableCompression bool            `yaml:"enableCompression,omitempty" mapstructure:"enableCompression,omitempty" json:"enableCompression,omitempty"`
+	AllowedOrigins    []string        `yaml:"allowedOrigins,omitempty" mapstructure:"allowedOrigins,omitempty" json:"allowedOrigins,omitempty"`
+}
+
+// AuthConfig configures NewAuthenticators, each method is enabled by setting its credentials.
+type AuthConfig struct {
+	APIKeyHeader string `yaml:"apiKeyHeader,omitempty" mapstructure:"apiKeyHeader,omitempty" json:"apiKeyHeader,omitempty"`
+	// APIKeys maps principal name to api key.
+	APIKeys map[string]string `yaml:"apiKeys,omitempty" mapstructure:"apiKeys,omitempty" json:"apiKeys,omitempty"`
+
+	BasicRealm string `yaml:"basicRealm,omitempty" mapstructure:"basicRealm,omitempty" json:"basicRealm,omitempty"`
+	// BasicUsers maps user name to bcrypt hash of the password.
+	BasicUsers map[string]string `yaml:"basicUsers,omitempty" mapstructure:"basicUsers,omitempty" json:"basicUsers,omitempty"`
+
+	// HMACKeys maps key id to secret.
+	HMACKeys        map[string]string `yaml:"hmacKeys,omitempty" mapstructure:"hmacKeys,omitempty" json:"hmacKeys,omitempty"`
+	HMACMaxSkew     config.Duration   `yaml:"hmacMaxSkew,omitempty" mapstructure:"hmacMaxSkew,omitempty" json:"hmacMaxSkew,omitempty"`
+	HMACMaxBodySize int64             `yaml:"hmacMaxBodySize,omitempty" mapstructure:"hmacMaxBodySize,omitempty" json:"hmacMaxBodySize,omitempty"`
+
+	JWKSFile    string          `yaml:"jwksFile,omitempty" mapstructure:"jwksFile,omitempty" json:"jwksFile,omitempty"`
+	JWTIssuer   string          `yaml:"jwtIssuer,omitempty" mapstructure:"jwtIssuer,omitempty" json:"jwtIssuer,omitempty"`
+	JWTAudience string          `yaml:"jwtAudience,omitempty" mapstructure:"jwtAudience,omitempty" json:"jwtAudience,omitempty"`
+	JWTLeeway   config.Duration `yaml:"jwtLeeway,omitempty" mapstructure:"jwtLeeway,omitempty" json:"jwtLeeway,omitempty"`
+}
+
+// ProxyConfig configures HttpD.ProxyHandler. The upstream path is the path of the upstream url, AddPrefix and
+// the request path without StripPrefix. FlushInterval negative flushes after each write.
+type ProxyConfig struct {
+	Upstreams     []string        `yaml:"upstreams" mapstructure:"upstreams" json:"upstreams"`
+	Balance       string          `yaml:"balance,omitempty" mapstructure:"balance,omitempty" json:"balance,omitempty"`
+	MaxFails      int             `yaml:"maxFails,omitempty" mapstructure:"maxFails,omitempty" json:"maxFails,omitempty"`
+	EjectDuration config.Duration `yaml:"ejectDuration,omitempty" mapstructure:"ejectDuration,omitempty" json:"ejectDuration,omitempty"`
+	FlushInterval config.Duration `yaml:"flushInterval,omitempty" mapstructure:"flushInterval,omitempty" json:"flushInterval,omitempty"`
+	StripPrefix   string          `yaml:"stripPrefix,omitempty" mapstructure:"stripPrefix,omitempty" json:"stripPrefix,omitempty"`
+	AddPrefix     string          `yaml:"addPrefix,omitempty" mapstructure:"addPrefix,omitempty" json:"addPrefix,omitempty"`
+	PreserveHost  bool            `yaml:"preserveHost,omitempty" mapstructure:"preserveHost,omitempty" json:"preserveHost,omitempty"`
+
+	SetHeaders            map[string]string `yaml:"setHeaders,omitempty" mapstructure:"setHeaders,omitempty" json:"setHeaders,omitempty"`
+	RemoveHeaders         []string          `yaml:"removeHeaders,omitempty" mapstructure:"removeHeaders,omitempty" json:"removeHeaders,omitempty"`
+	SetResponseHeaders    map[string]string `yaml:"setResponseHeaders,omitempty" mapstructure:"setResponseHeaders,omitempty" json:"setResponseHeaders,omitempty"`
+	RemoveResponseHeaders []string          `yaml:"removeResponseHeaders,omitempty" mapstructure:"removeResponseHeaders,omitempty" json:"removeResponseHeaders,omitempty"`
+}
+
+// CORSConfig answers preflight requests itself and adds CORS headers to requests from AllowedOrigins.
+// AllowedOrigins may be * or contain one wildcard like https://*.example.com, AllowedHeaders may be *
+// to allow any requested header.
+type CORSConfig struct {
+	AllowedOrigins   []string        `yaml:"allowedOrigins,omitempty" mapstructure:"allowedOrigins,omitempty" json:"allowedOrigins,omitempty"`
+	AllowedMethods   []string        `yaml:"allowedMethods,omitempty" mapstructure:"allowedMethods,omitempty" json:"allowedMethods,omitempty"`
+	AllowedHeaders   []string        `yaml:"allowedHeaders,omitempty" mapstructure:"allowedHeaders,omitempty" json:"allowedHeaders,omitempty"`
+	ExposedHeaders   []string        `yaml:"exposedHeaders,omitempty" mapstructure:"exposedHeaders,omitempty" json:"exposedHeaders,omitempty"`
+	AllowCredentials bool            `yaml:"allowCredentials,omitempty" mapstructure:"allowCredentials,omitempty" json:"allowCredentials,omitempty"`
+	MaxAge           config.Duration `yaml:"maxAge,omitempty" mapstructure:"maxAge,omitempty" json:"maxAge,omitempty"`
+}
+
+// SecurityHeadersConfig sets response headers before the handler runs, empty values are not sent.
+type SecurityHeadersConfig struct {
+	HSTSMaxAge            config.Duration `yaml:"hstsMaxAge,omitempty" mapstructure:"hstsMaxAge,omitempty" json:"hstsMaxAge,omitempty"`
+	HSTSIncludeSubdomains bool            `yaml:"hstsIncludeSubdomains,omitempty" mapstructure:"hstsIncludeSubdomains,omitempty" json:"hstsIncludeSubdomains,omitempty"`
+	HSTSPreload           bool            `yaml:"hstsPreload,omitempty" mapstructure:"hstsPreload,omitempty" json:"hstsPreload,omitempty"`
+	ContentTypeNosniff    bool            `yaml:"contentTypeNosniff,omitempty" mapstructure:"contentTypeNosniff,omitempty" json:"contentTypeNosniff,omitempty"`
+	FrameOptions          string          `yaml:"frameOptions,omitempty" mapstructure:"frameOptions,omitempty" json:"frameOptions,omitempty"`
+	ContentSecurityPolicy string          `yaml:"contentSecurityPolicy,omitempty" mapstructure:"contentSecurityPolicy,omitempty" json:"contentSecurityPolicy,omitempty"`
+	ReferrerPolicy        string          `yaml:"referrerPolicy,omitempty" mapstructure:"referrerPolicy,omitempty" json:"referrerPolicy,omitempty"`
+}
+
+// StaticConfig configures NewStaticHandler. SPAFallback serves Index for missing paths without
+// a file extension, Precompressed serves name.gz instead of name to clients accepting gzip.
+type StaticConfig struct {
+	Index            string          `yaml:"index,omitempty" mapstructure:"index,omitempty" json:"index,omitempty"`
+	SPAFallback      bool            `yaml:"spaFallback,omitempty" mapstructure:"spaFallback,omitempty" json:"spaFallback,omitempty"`
+	DirectoryListing bool            `yaml:"directoryListing,omitempty" mapstructure:"directoryListing,omitempty" json:"directoryListing,omitempty"`
+	Precompressed    bool            `yaml:"precompressed,omitempty" mapstructure:"precompressed,omitempty" json:"precompressed,omitempty"`
+	MaxAge           config.Duration `yaml:"maxAge,omitempty" mapstructure:"maxAge,omitempty" json:"maxAge,omitempty"`
 }
 
 func NewConfig(addr string) *Config {
 	return &Config{
-		Addr:              addr,
-		WriteTimeout:      DefaultWriteTimeout,
-		ReadTimeout:       DefaultReadTimeout,
-		ReadHeaderTimeout: DefaultReadHeaderTimeout,
-		IdleTimeout:       DefaultIdleTimeout,
-		MonitorInterval:   DefaultMonitorInterval,
+		Addr:               addr,
+		WriteTimeout:       DefaultWriteTimeout,
+		ReadTimeout:        DefaultReadTimeout,
+		ReadHeaderTimeout:  DefaultReadHeaderTimeout,
+		IdleTimeout:        DefaultIdleTimeout,
+		MonitorInterval:    DefaultMonitorInterval,
+		MaxHeaderBytes:     DefaultMaxHeaderBytes,
+		MaxJSONBodySize:    DefaultMaxJSONBodySize,
+		MinUploadRateGrace: DefaultMinUploadRateGrace,
+	}
+}
+
+func NewListenerConfig(network string, addr string) *ListenerConfig {
+	lc := &ListenerConfig{
+		Network: network,
+		Addr:    addr,
+	}
+	if network == NetworkUnix {
+		lc.FileMode = DefaultUnixFileMode
+	}
+	return lc
+}
+
+func NewCompressionConfig() *CompressionConfig {
+	return &CompressionConfig{
+		MinSize:             DefaultCompressionMinSize,
+		Level:               DefaultCompressionLevel,
+		ContentTypes:        DefaultCompressionContentTypes,
+		MaxDecompressedSize: DefaultCompressionMaxDecompressedSize,
+	}
+}
+
+func NewHTTP2Config() *HTTP2Config {
+	return &HTTP2Config{
+		MaxConcurrentStreams: DefaultHTTP2MaxConcurrentStreams,
+		MaxReadFrameSize:     DefaultHTTP2MaxReadFrameSize,
+		IdleTimeout:          DefaultHTTP2IdleTimeout,
+	}
+}
+
+func NewStaticConfig() *StaticConfig {
+	return &StaticConfig{
+		Index:         DefaultStaticIndex,
+		Precompressed: DefaultStaticPrecompressed,
+	}
+}
+
+func NewSSEConfig() *SSEConfig {
+	return &SSEConfig{
+		HeartbeatInterval: DefaultSSEHeartbeatInterval,
+		ReplaySize:        DefaultSSEReplaySize,
+	}
+}
+
+func NewWebSocketConfig() *WebSocketConfig {
+	return &WebSocketConfig{
+		MaxMessageSize:   DefaultWebSocketMaxMessageSize,
+		WriteQueueSize:   DefaultWebSocketWriteQueueSize,
+		PingInterval:     DefaultWebSocketPingInterval,
+		PongTimeout:      DefaultWebSocketPongTimeout,
+		WriteTimeout:     DefaultWebSocketWriteTimeout,
+		HandshakeTimeout: DefaultWebSocketHandshakeTimeout,
+	}
+}
+
+func NewAuthConfig() *AuthConfig {
+	return &AuthConfig{
+		APIKeyHeader:    DefaultAuthAPIKeyHeader,
+		BasicRealm:      DefaultAuthBasicRealm,
+		HMACMaxSkew:     DefaultAuthHMACMaxSkew,
+		HMACMaxBodySize: DefaultAuthHMACMaxBodySize,
+		JWTLeeway:       DefaultAuthJWTLeeway,
+	}
+}
+
+func NewProxyConfig(upstreams ...string) *ProxyConfig {
+	return &ProxyConfig{
+		Upstreams:     upstreams,
+		Balance:       DefaultProxyBalance,
+		MaxFails:      DefaultProxyMaxFails,
+		EjectDuration: DefaultProxyEjectDuration,
+	}
+}
+
+func NewCORSConfig(allowedOrigins ...string) *CORSConfig {
+	return &CORSConfig{
+		AllowedOrigins: allowedOrigins,
+		AllowedMethods: DefaultCORSAllowedMethods,
+		MaxAge:         DefaultCORSMaxAge,
+	}
+}
+
+func NewSecurityHeadersConfig() *SecurityHeadersConfig {
+	return &SecurityHeadersConfig{
+		ContentTypeNosniff: DefaultSecurityContentTypeNosniff,
+		FrameOptions:       DefaultSecurityFrameOptions,
+		ReferrerPolicy:     DefaultSecurityReferrerPolicy,
+	}
+}
+
+func (c *Config) listenerConfigs() []*ListenerConfig {
+	if len(c.Listeners) == 0 {
+		return []*ListenerConfig{NewListenerConfig(DefaultNetwork, c.Addr)}
+	}
+	return c.Listeners
+}
+
+func (c *LimitConfig) burst() int {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	if c.Rate < 1 {
+		return 1
 	}
+	return int(c.Rate)
 }
@@ -0,0 +1,150 @@
+package httpd
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerOrigin                        = "Origin"
+	headerAccessControlRequestMethod    = "Access-Control-Request-Method"
+	headerAccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	headerAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	headerAccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	headerAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	headerAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	headerAccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	headerAccessControlMaxAge           = "Access-Control-Max-Age"
+)
+
+type corsHandler struct {
+	next   http.Handler
+	config *CORSConfig
+
+	allowAllOrigins bool
+	allowAllHeaders bool
+	methods         string
+	headers         string
+	exposed         string
+	maxAge          string
+}
+
+func newCORSHandler(next http.Handler, c *CORSConfig) *corsHandler {
+	h := &corsHandler{
+		next:    next,
+		config:  c,
+		methods: strings.Join(c.AllowedMethods, ", "),
+		headers: strings.Join(c.AllowedHeaders, ", "),
+		exposed: strings.Join(c.ExposedHeaders, ", "),
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			h.allowAllOrigins = true
+		}
+	}
+	for _, hd := range c.AllowedHeaders {
+		if hd == "*" {
+			h.allowAllHeaders = true
+		}
+	}
+	if c.MaxAge > 0 {
+		h.maxAge = strconv.Itoa(int(c.MaxAge.ToDuration().Seconds()))
+	}
+	return h
+}
+
+func (h *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get(headerOrigin)
+	if origin == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	header := w.Header()
+	header.Add(headerVary, headerOrigin)
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get(headerAccessControlRequestMethod) != ""
+	if preflight {
+		header.Add(headerVary, headerAccessControlRequestMethod)
+		header.Add(headerVary, headerAccessControlRequestHeaders)
+	}
+
+	if !h.originAllowed(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if h.allowAllOrigins && !h.config.AllowCredentials {
+		header.Set(headerAccessControlAllowOrigin, "*")
+	} else {
+		header.Set(headerAccessControlAllowOrigin, origin)
+	}
+	if h.config.AllowCredentials {
+		header.Set(headerAccessControlAllowCredentials, "true")
+	}
+
+	if !preflight {
+		if h.exposed != "" {
+			header.Set(headerAccessControlExposeHeaders, h.exposed)
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if !h.methodAllowed(r.Header.Get(headerAccessControlRequestMethod)) {
+		header.Del(headerAccessControlAllowOrigin)
+		header.Del(headerAccessControlAllowCredentials)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	header.Set(headerAccessControlAllowMethods, h.methods)
+	if h.allowAllHeaders {
+		if reqHeaders := r.Header.Get(headerAccessControlRequestHeaders); reqHeaders != "" {
+			header.Set(headerAccessControlAllowHeaders, reqHeaders)
+		}
+	} else if h.headers != "" {
+		header.Set(headerAccessControlAllowHeaders, h.headers)
+	}
+	if h.maxAge != "" {
+		header.Set(headerAccessControlMaxAge, h.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *corsHandler) originAllowed(origin string) bool {
+	if h.allowAllOrigins {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range h.config.AllowedOrigins {
+		if matchOrigin(strings.ToLower(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches origin against a pattern which may contain one *, like https://*.example.com.
+func matchOrigin(pattern string, origin string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+func (h *corsHandler) methodAllowed(method string) bool {
+	for _, m := range h.config.AllowedMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
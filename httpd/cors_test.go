@@ -0,0 +1,64 @@
+package httpd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCORSTestHandler(c *CORSConfig) http.Handler {
+	hc := NewConfig("127.0.0.1:0")
+	hc.CORS = c
+	hc.SecurityHeaders = NewSecurityHeadersConfig()
+	return New(hc).wrap(newTextRouter("ok"))
+}
+
+func TestCORSHandler_Preflight(t *testing.T) {
+	c := NewCORSConfig("https://*.example.com")
+	c.AllowedHeaders = []string{"*"}
+	h := newCORSTestHandler(c)
+
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	req.Header.Set("Access-Control-Request-Headers", "X-Token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Token", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	req.Header.Set("Origin", "https://example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), "origin should not match wildcard")
+}
+
+func TestCORSHandler_Request(t *testing.T) {
+	c := NewCORSConfig("https://a.com")
+	c.AllowCredentials = true
+	c.ExposedHeaders = []string{"X-Request-Id"}
+	h := newCORSTestHandler(c)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://a.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Equal(t, "https://a.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+
+	req.Header.Set("Origin", "https://b.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
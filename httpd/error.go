@@ -0,0 +1,16 @@
+package httpd
+
+const (
+    ErrListen           = "Listen fail, network: %s, addr: %s"
+    ErrConfigureHTTP2   = "Configure http2 fail, network: %s, addr: %s"
+    ErrUnknownNetwork   = "Unknown network: %s"
+    ErrNotSocket        = "File exists and is not a socket: %s"
+    ErrSocketInUse      = "Socket is in use: %s"
+    ErrInvalidFd        = "Invalid listen fd: %s"
+    ErrLoadJWKS         = "Load jwks fail, file: %s"
+    ErrParseJWK         = "Parse jwk fail, kid: %s"
+    ErrUnsupportedCurve = "Unsupported curve: %s"
+    ErrUnsupportedKty   = "Unsupported kty: %s"
+    ErrNoUpstream       = "No upstream"
+    ErrInvalidUpstream  = "Invalid upstream: %s"
+)
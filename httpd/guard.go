@@ -0,0 +1,229 @@
+package httpd
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatBodyTooLarge = "bodyTooLarge"
+	StatBodyTooSlow  = "bodyTooSlow"
+	// StatHeaderCountExceeded counts the 431 of MaxHeaderCount only, http.Server answers MaxHeaderBytes
+	// with 431 before any handler, so those are not counted
+	StatHeaderCountExceeded = "headerCountExceeded"
+)
+
+var ErrBodyTooSlow = errors.New("Request body too slow")
+
+type connKey struct{}
+
+// connContext makes the connection reachable from handlers, see guardBody.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+type guardHandler struct {
+	next   http.Handler
+	config *Config
+	owner  *HttpD
+}
+
+func (h *guardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.config.MaxHeaderCount > 0 && headerCount(r) > h.config.MaxHeaderCount {
+		h.owner.stats.Incr(StatHeaderCountExceeded, 1)
+		http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
+	maxSize := h.maxBodySize(r)
+	if maxSize > 0 && r.ContentLength > maxSize {
+		h.owner.stats.Incr(StatBodyTooLarge, 1)
+		w.Header().Set("Connection", "close")
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if r.Body == nil || r.Body == http.NoBody || (maxSize <= 0 && h.config.MinUploadRate <= 0) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	gb := &guardBody{
+		ReadCloser: r.Body,
+		remain:     maxSize,
+		minRate:    h.config.MinUploadRate,
+		deadline:   time.Now().Add(h.config.MinUploadRateGrace.ToDuration()),
+		owner:      h.owner,
+	}
+	// deadlines of a HTTP/2 connection would hit all its streams
+	if conn, ok := r.Context().Value(connKey{}).(net.Conn); ok && r.ProtoMajor == 1 {
+		gb.conn = conn
+		if srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok && srv.ReadTimeout > 0 {
+			gb.readDeadline = time.Now().Add(srv.ReadTimeout)
+		}
+	}
+	r.Body = gb
+
+	gw := &guardWriter{ResponseWriter: w, body: gb}
+	h.next.ServeHTTP(gw, r)
+	gb.restoreDeadline()
+
+	// the handler gave up on the body without answering, answer for it
+	if gb.err != nil && !gw.wroteHeader {
+		status := http.StatusRequestEntityTooLarge
+		if errors.Is(gb.err, ErrBodyTooSlow) {
+			status = http.StatusRequestTimeout
+		}
+		http.Error(gw, http.StatusText(status), status)
+	}
+}
+
+// headerCount counts header values, the header size is limited by http.Server.MaxHeaderBytes.
+func headerCount(r *http.Request) int {
+	count := 0
+	for _, vs := range r.Header {
+		count += len(vs)
+	}
+	return count
+}
+
+// maxBodySize returns the limit of the first BodyLimitConfig matching r, or Config.MaxBodySize.
+func (h *guardHandler) maxBodySize(r *http.Request) int64 {
+	for _, bl := range h.config.BodyLimits {
+		if strings.HasPrefix(r.URL.Path, bl.PathPrefix) {
+			return bl.MaxBodySize
+		}
+	}
+	return h.config.MaxBodySize
+}
+
+// guardBody fails reads over remain bytes with ErrBodyTooLarge, and reads slower than minRate bytes
+// per second on average after the grace deadline with ErrBodyTooSlow. With conn, the read deadline
+// of the connection is set so that a stalled client is cut even while the handler is blocked in Read,
+// it never goes past readDeadline, the one of http.Server.ReadTimeout, which is restored on EOF and Close.
+type guardBody struct {
+	io.ReadCloser
+	conn         net.Conn
+	readDeadline time.Time
+	deadlineSet  bool
+
+	remain   int64
+	minRate  int64
+	deadline time.Time
+	read     int64
+	owner    *HttpD
+
+	once sync.Once
+	err  error
+}
+
+func (b *guardBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.remain > 0 && int64(len(p)) > b.remain+1 {
+		p = p[:b.remain+1]
+	}
+
+	if b.minRate > 0 {
+		// the time by which one more byte must have arrived to keep the average rate
+		next := b.deadline.Add(time.Duration(float64(b.read+1) / float64(b.minRate) * float64(time.Second)))
+		if time.Now().After(next) {
+			return 0, b.fail(ErrBodyTooSlow, StatBodyTooSlow)
+		}
+		if b.conn != nil {
+			if !b.readDeadline.IsZero() && next.After(b.readDeadline) {
+				next = b.readDeadline
+			}
+			_ = b.conn.SetReadDeadline(next)
+			b.deadlineSet = true
+		}
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	if b.remain > 0 && b.read > b.remain {
+		return 0, b.fail(ErrBodyTooLarge, StatBodyTooLarge)
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() && b.minRate > 0 {
+		return n, b.fail(ErrBodyTooSlow, StatBodyTooSlow)
+	}
+	if err == io.EOF {
+		b.restoreDeadline()
+	}
+	return n, err
+}
+
+func (b *guardBody) Close() error {
+	b.restoreDeadline()
+	return b.ReadCloser.Close()
+}
+
+func (b *guardBody) restoreDeadline() {
+	if b.deadlineSet {
+		_ = b.conn.SetReadDeadline(b.readDeadline)
+		b.deadlineSet = false
+	}
+}
+
+func (b *guardBody) fail(err error, stat string) error {
+	b.once.Do(func() {
+		b.err = err
+		b.owner.stats.Incr(stat, 1)
+	})
+	return b.err
+}
+
+// guardWriter records whether the handler answered, so guardHandler does not answer twice.
+// Once the body failed the connection is closed after the response, net/http would otherwise
+// wait for the rest of the body to keep it alive.
+type guardWriter struct {
+	http.ResponseWriter
+	body        *guardBody
+	wroteHeader bool
+}
+
+func (w *guardWriter) WriteHeader(status int) {
+	if status >= http.StatusOK && !w.wroteHeader {
+		w.wroteHeader = true
+		if w.body.err != nil {
+			w.Header().Set("Connection", "close")
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *guardWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *guardWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+func (w *guardWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter does not implement http.Hijacker")
+	}
+	w.wroteHeader = true
+	return hj.Hijack()
+}
@@ -0,0 +1,99 @@
+package httpd
+
+import (
+	"bufio"
+	"context"
+	"github.com/donkeywon/gtil/config"
+	"github.com/donkeywon/gtil/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuardHandler_BodySize(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.MaxBodySize = 8
+	c.BodyLimits = []*BodyLimitConfig{{PathPrefix: "/upload", MaxBodySize: 64}}
+	c.MaxHeaderCount = 3
+	h := New(c)
+
+	handler := h.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.WriteError(w, r, err)
+		}
+	}))
+
+	serve := func(path string, body string, contentLength int64) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/", "12345678", 8))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/", "123456789", 9))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/", "123456789", -1), "chunked body over limit")
+	assert.Equal(t, http.StatusOK, serve("/upload", strings.Repeat("a", 64), -1), "route limit")
+	assert.Equal(t, float64(2), h.Statistics()[StatBodyTooLarge])
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header["X-A"] = []string{"1", "2", "3", "4"}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, w.Code)
+	assert.Equal(t, float64(1), h.Statistics()[StatHeaderCountExceeded])
+
+	silent := h.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+	}))
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456789"))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	silent.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "guard should answer when the handler does not")
+}
+
+func TestGuardHandler_NotConfigured(t *testing.T) {
+	h := New(NewConfig("127.0.0.1:0"))
+	_, ok := h.wrap(http.NotFoundHandler()).(*guardHandler)
+	assert.False(t, ok, "guard should be skipped without limits")
+}
+
+func TestGuardHandler_SlowUpload(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.ReadTimeout = config.Duration(5 * time.Second)
+	c.MinUploadRate = 10
+	c.MinUploadRateGrace = config.Duration(100 * time.Millisecond)
+	h := New(c)
+
+	r := mux.NewRouter()
+	r.Handle("/", h.JSONHandler(func(r *http.Request, in *echoReq) error { return nil }))
+	h.SetHandler(r)
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	conn, err := net.Dial("tcp", h.Addr().String())
+	assert.NoError(t, err, "dial fail")
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{"))
+	assert.NoError(t, err, "write fail")
+
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NoError(t, err, "read response fail")
+	assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "stalled client should be cut before ReadTimeout")
+	assert.Equal(t, float64(1), h.Statistics()[StatBodyTooSlow])
+}
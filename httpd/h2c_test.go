@@ -0,0 +1,96 @@
+package httpd
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/donkeywon/gtil/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpD_H2C(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.HTTP2 = NewHTTP2Config()
+	c.HTTP2.H2C = true
+	c.MaxHeaderBytes = 4096
+
+	h := New(c)
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+	h.SetHandler(r)
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	h2Client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	assert.Equal(t, "HTTP/2.0", get(t, h2Client, "http://"+h.Addr().String()+"/"))
+	assert.Equal(t, "HTTP/1.1", get(t, http.DefaultClient, "http://"+h.Addr().String()+"/"))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+h.Addr().String()+"/", nil)
+	req.Header.Set("X-Large", strings.Repeat("a", 16384))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "request fail")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+}
+
+func TestHttpD_H2CClose(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.HTTP2 = NewHTTP2Config()
+	c.HTTP2.H2C = true
+
+	h := New(c)
+	r := mux.NewRouter()
+	r.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	h.SetHandler(r)
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+
+	h2Client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := h2Client.Get("http://" + h.Addr().String() + "/stream")
+	assert.NoError(t, err, "request fail")
+	defer resp.Body.Close()
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(resp.Body, buf)
+	assert.NoError(t, err, "read stream fail")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		done <- err
+	}()
+
+	assert.NoError(t, h.Close())
+	select {
+	case err = <-done:
+		assert.Error(t, err, "stream should be torn down")
+	case <-time.After(2 * time.Second):
+		t.Fatal("h2c stream still open after Close")
+	}
+}
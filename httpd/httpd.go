@@ -1,9 +1,21 @@
 package httpd
 
 import (
+    "github.com/donkeywon/gtil/config"
     "github.com/donkeywon/gtil/service"
+    "github.com/donkeywon/gtil/statistics"
     "github.com/gorilla/mux"
+    "github.com/pkg/errors"
+    "go.uber.org/multierr"
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
+    "io"
+    "net"
     "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
 )
 
 const (
@@ -15,25 +27,91 @@ type HttpD struct {
 
     config *Config
 
+    listeners []*listener
+    limits    []*limitRule
+    routes    map[string]*routeTable
+
+    trackedMu sync.Mutex
+    tracked   map[io.Closer]struct{}
+
+    stats statistics.Statistics
+}
+
+type listener struct {
+    config *ListenerConfig
     server *http.Server
+    h2s    *http2.Server
+    ln     net.Listener
+}
+
+func pickDuration(d config.Duration, def config.Duration) time.Duration {
+    if d == 0 {
+        d = def
+    }
+    if d < 0 {
+        return 0
+    }
+    return d.ToDuration()
 }
 
-func newHTTPServer(config *Config) *http.Server {
+func newHTTPServer(config *Config, lc *ListenerConfig) *http.Server {
     return &http.Server{
-        Addr:              config.Addr,
-        ReadTimeout:       config.ReadTimeout.ToDuration(),
-        ReadHeaderTimeout: config.ReadHeaderTimeout.ToDuration(),
-        WriteTimeout:      config.WriteTimeout.ToDuration(),
-        IdleTimeout:       config.IdleTimeout.ToDuration(),
+        Addr:              lc.Addr,
+        ReadTimeout:       pickDuration(lc.ReadTimeout, config.ReadTimeout),
+        ReadHeaderTimeout: pickDuration(lc.ReadHeaderTimeout, config.ReadHeaderTimeout),
+        WriteTimeout:      pickDuration(lc.WriteTimeout, config.WriteTimeout),
+        IdleTimeout:       pickDuration(lc.IdleTimeout, config.IdleTimeout),
+        MaxHeaderBytes:    config.MaxHeaderBytes,
+        ConnContext:       connContext,
+    }
+}
+
+func newHTTP2Server(config *Config) *http2.Server {
+    if config.HTTP2 == nil {
+        return nil
+    }
+    return &http2.Server{
+        MaxConcurrentStreams: config.HTTP2.MaxConcurrentStreams,
+        MaxReadFrameSize:     config.HTTP2.MaxReadFrameSize,
+        IdleTimeout:          pickDuration(config.HTTP2.IdleTimeout, config.IdleTimeout),
     }
 }
 
 func New(config *Config) *HttpD {
-    return &HttpD{
+    s := &HttpD{
         BaseService: service.NewBase(),
         config:      config,
-        server:      newHTTPServer(config),
+        limits:      newLimitRules(config.Limits),
+        stats:       statistics.New(StatRateLimited, StatConcurrencyLimited, StatWebSocketConns, StatWebSocketOpened,
+            StatBodyTooLarge, StatBodyTooSlow, StatHeaderCountExceeded, StatProxyEjected),
+        routes:      make(map[string]*routeTable),
+        tracked:     make(map[io.Closer]struct{}),
     }
+
+    for _, lc := range config.listenerConfigs() {
+        l := &listener{
+            config: lc,
+            server: newHTTPServer(config, lc),
+            h2s:    newHTTP2Server(config),
+        }
+
+        table, ok := s.routes[lc.Handler]
+        if !ok {
+            table = newRouteTable()
+            s.routes[lc.Handler] = table
+        }
+
+        h := s.wrap(table)
+        if l.h2s != nil && config.HTTP2.H2C {
+            h = s.h2cHandler(h, l.h2s)
+        }
+        l.server.Handler = h
+        l.server.RegisterOnShutdown(s.closeTracked)
+
+        s.listeners = append(s.listeners, l)
+    }
+
+    return s
 }
 
 func (s *HttpD) Name() string {
@@ -41,22 +119,199 @@ func (s *HttpD) Name() string {
 }
 
 func (s *HttpD) Open() error {
-    go s.serve()
+    for _, l := range s.listeners {
+        if l.h2s == nil {
+            continue
+        }
+        err := http2.ConfigureServer(l.server, l.h2s)
+        if err != nil {
+            return errors.Wrapf(err, ErrConfigureHTTP2, l.config.Network, l.config.Addr)
+        }
+    }
+
+    for i, l := range s.listeners {
+        ln, err := listen(l.config)
+        if err != nil {
+            for _, opened := range s.listeners[:i] {
+                _ = opened.ln.Close()
+                opened.ln = nil
+            }
+            return errors.Wrapf(err, ErrListen, l.config.Network, l.config.Addr)
+        }
+        l.ln = ln
+    }
+
+    for _, l := range s.listeners {
+        go s.serve(l)
+    }
     return nil
 }
 
 func (s *HttpD) Close() error {
-    return s.server.Close()
+    s.closeTracked()
+
+    var err error
+    for _, l := range s.listeners {
+        err = multierr.Append(err, l.server.Close())
+    }
+    return err
 }
 
 func (s *HttpD) Shutdown() error {
-    return s.server.Shutdown(s.Context())
+    var err error
+    for _, l := range s.listeners {
+        err = multierr.Append(err, l.server.Shutdown(s.Context()))
+    }
+    return err
 }
 
+// SetHandler sets the router of listeners without a handler name.
+// It is safe to call on a running HttpD, requests in flight finish on the old router.
 func (s *HttpD) SetHandler(router *mux.Router) {
-    s.server.Handler = router
+    s.SetNamedHandler("", router)
+}
+
+// SetNamedHandler sets the router of listeners whose ListenerConfig.Handler equals name.
+func (s *HttpD) SetNamedHandler(name string, router *mux.Router) {
+    if table, ok := s.routes[name]; ok {
+        table.setRouter(router)
+    }
+}
+
+// Mount serves requests under prefix with h instead of the router of listeners without a handler name,
+// mounting on the same prefix again replaces h. It is safe to call on a running HttpD.
+func (s *HttpD) Mount(prefix string, h http.Handler) {
+    s.MountNamed("", prefix, h)
+}
+
+func (s *HttpD) Unmount(prefix string) {
+    s.UnmountNamed("", prefix)
+}
+
+func (s *HttpD) MountNamed(name string, prefix string, h http.Handler) {
+    if table, ok := s.routes[name]; ok {
+        table.mount(prefix, h)
+    }
+}
+
+func (s *HttpD) UnmountNamed(name string, prefix string) {
+    if table, ok := s.routes[name]; ok {
+        table.unmount(prefix)
+    }
+}
+
+// Routes lists the current routes of all handlers, handlers mounted which are not
+// a *mux.Router are listed by their prefix.
+func (s *HttpD) Routes() []*RouteInfo {
+    names := make([]string, 0, len(s.routes))
+    for name := range s.routes {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var routes []*RouteInfo
+    for _, name := range names {
+        routes = append(routes, s.routes[name].routes(name)...)
+    }
+    return routes
 }
 
-func (s *HttpD) serve() {
-    s.AppendError(s.server.ListenAndServe())
+func (s *HttpD) Statistics() map[string]float64 {
+    return s.stats.Export()
+}
+
+// Addr returns the address actually bound by the first listener, useful when Config.Addr uses port 0.
+// It returns nil before Open.
+func (s *HttpD) Addr() net.Addr {
+    if len(s.listeners) == 0 || s.listeners[0].ln == nil {
+        return nil
+    }
+    return s.listeners[0].ln.Addr()
+}
+
+// Addrs returns the bound addresses of all listeners in the order of Config.Listeners.
+func (s *HttpD) Addrs() []net.Addr {
+    addrs := make([]net.Addr, 0, len(s.listeners))
+    for _, l := range s.listeners {
+        if l.ln != nil {
+            addrs = append(addrs, l.ln.Addr())
+        }
+    }
+    return addrs
+}
+
+// wrap applies the middlewares enabled by Config in front of h.
+func (s *HttpD) wrap(h http.Handler) http.Handler {
+    if s.config.Compression != nil {
+        h = newCompressHandler(h, s.config.Compression)
+    }
+    if s.config.MaxBodySize > 0 || len(s.config.BodyLimits) > 0 || s.config.MinUploadRate > 0 || s.config.MaxHeaderCount > 0 {
+        h = &guardHandler{next: h, config: s.config, owner: s}
+    }
+    if len(s.limits) > 0 {
+        h = &limitHandler{next: h, rules: s.limits, stats: s.stats}
+    }
+    if s.config.CORS != nil {
+        h = newCORSHandler(h, s.config.CORS)
+    }
+    if s.config.SecurityHeaders != nil {
+        h = newSecureHandler(h, s.config.SecurityHeaders)
+    }
+    return h
+}
+
+// track registers long living streams which http.Server does not end by itself on Close and Shutdown.
+func (s *HttpD) track(c io.Closer) {
+    s.trackedMu.Lock()
+    defer s.trackedMu.Unlock()
+    s.tracked[c] = struct{}{}
+}
+
+func (s *HttpD) untrack(c io.Closer) {
+    s.trackedMu.Lock()
+    defer s.trackedMu.Unlock()
+    delete(s.tracked, c)
+}
+
+// h2cHandler serves h2c and tracks the connections it takes over from the http.Server,
+// which neither closes nor waits for hijacked connections.
+func (s *HttpD) h2cHandler(h http.Handler, h2s *http2.Server) http.Handler {
+    h2 := h2c.NewHandler(h, h2s)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if isH2CRequest(r) {
+            if conn, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+                // h2c serves the whole connection before returning
+                s.track(conn)
+                defer s.untrack(conn)
+            }
+        }
+        h2.ServeHTTP(w, r)
+    })
+}
+
+func isH2CRequest(r *http.Request) bool {
+    if r.Method == "PRI" && r.URL.Path == "*" && r.ProtoMajor == 2 {
+        return true
+    }
+    return strings.EqualFold(r.Header.Get("Upgrade"), "h2c") && r.Header.Get("HTTP2-Settings") != ""
+}
+
+func (s *HttpD) closeTracked() {
+    s.trackedMu.Lock()
+    tracked := make([]io.Closer, 0, len(s.tracked))
+    for c := range s.tracked {
+        tracked = append(tracked, c)
+    }
+    s.trackedMu.Unlock()
+
+    for _, c := range tracked {
+        _ = c.Close()
+    }
+}
+
+func (s *HttpD) serve(l *listener) {
+    err := l.server.Serve(l.ln)
+    if err != nil && err != http.ErrServerClosed {
+        s.AppendError(err)
+    }
 }
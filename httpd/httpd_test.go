@@ -11,17 +11,32 @@ import (
 )
 
 var (
-    cfg    = NewConfig("127.0.0.1:5678")
+    cfg    = NewConfig("127.0.0.1:0")
     log, _ = logger.Default()
-    httpd  = New(cfg)
 )
 
 func TestHttpD_Open(t *testing.T) {
-    httpd.WithLogger(httpd, log)
-    httpd.WithContext(context.Background())
+    h := New(cfg)
 
-    err := service.DoOpen(httpd, context.Background(), log)
+    err := service.DoOpen(h, context.Background(), log)
     assert.NoError(t, err, "open httpd fail")
+    assert.NotNil(t, h.Addr(), "addr is nil after open")
+    assert.NotEqual(t, "127.0.0.1:0", h.Addr().String(), "port 0 not resolved")
+
+    err = service.DoClose(h)
+    assert.NoError(t, err, "close fail")
+    assert.NoError(t, h.LastError(), "server closed is not an error")
+}
+
+func TestHttpD_OpenAddrInUse(t *testing.T) {
+    h := New(cfg)
+    err := service.DoOpen(h, context.Background(), log)
+    assert.NoError(t, err, "open httpd fail")
+    defer service.DoClose(h)
+
+    h2 := New(NewConfig(h.Addr().String()))
+    err = service.DoOpen(h2, context.Background(), log)
+    assert.Error(t, err, "open on used addr should fail")
 }
 
 func TestHttpD_Close(t *testing.T) {
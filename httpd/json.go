@@ -0,0 +1,246 @@
+package httpd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+)
+
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeProblemJSON = "application/problem+json"
+
+	ProblemTypeDefault = "about:blank"
+)
+
+var (
+	ErrBodyTooLarge = errors.New("Request body too large")
+
+	typeRequest = reflect.TypeOf((*http.Request)(nil))
+	typeError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Error carries the status of the response when returned by a handler.
+// Detail is sent to the client, the wrapped Err is only logged.
+type Error struct {
+	Status int
+	Detail string
+	Err    error
+}
+
+func NewError(status int, err error) *Error {
+	return &Error{
+		Status: status,
+		Err:    err,
+	}
+}
+
+func Errorf(status int, format string, args ...interface{}) *Error {
+	detail := fmt.Sprintf(format, args...)
+	return &Error{
+		Status: status,
+		Detail: detail,
+		Err:    errors.New(detail),
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return http.StatusText(e.Status)
+}
+
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Problem is the RFC 7807 problem details body of error responses.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// Validator is implemented by request bodies that check themselves after decoding.
+type Validator interface {
+	Validate() error
+}
+
+// StatusCoder is implemented by response bodies that want another status than 200.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// DecodeJSON decodes the request body into v, rejecting unknown fields, bodies larger
+// than maxSize and non JSON content types, maxSize <= 0 means DefaultMaxJSONBodySize.
+// v is validated if it implements Validator.
+// The returned error is an *Error with the status to respond.
+func DecodeJSON(r *http.Request, v interface{}, maxSize int64) error {
+	if ct := r.Header.Get(headerContentType); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || mediaType != ContentTypeJSON {
+			return Errorf(http.StatusUnsupportedMediaType, "Content-Type must be %s", ContentTypeJSON)
+		}
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return Errorf(http.StatusBadRequest, "Request body is empty")
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultMaxJSONBodySize
+	}
+	body := &limitedBody{ReadCloser: r.Body, remain: maxSize, err: ErrBodyTooLarge}
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if errors.Is(err, ErrBodyTooLarge) || errors.Is(err, ErrDecompressedTooLarge) {
+		return NewError(http.StatusRequestEntityTooLarge, err)
+	}
+	if errors.Is(err, ErrBodyTooSlow) {
+		return NewError(http.StatusRequestTimeout, err)
+	}
+	if err == io.EOF {
+		return Errorf(http.StatusBadRequest, "Request body is empty")
+	}
+	if err != nil {
+		return &Error{Status: http.StatusBadRequest, Detail: err.Error(), Err: err}
+	}
+	if dec.More() {
+		return Errorf(http.StatusBadRequest, "Request body must contain a single JSON value")
+	}
+
+	if validator, ok := v.(Validator); ok {
+		err = validator.Validate()
+		if err != nil {
+			var e *Error
+			if errors.As(err, &e) {
+				return err
+			}
+			return &Error{Status: http.StatusBadRequest, Detail: err.Error(), Err: err}
+		}
+	}
+
+	return nil
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set(headerContentType, ContentTypeJSON)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// WriteError responds err as a Problem and logs it with its stack, server errors at error level
+// and client errors at debug level. Details of errors which are not an *Error are not sent.
+func (s *HttpD) WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	p := &Problem{
+		Type:     ProblemTypeDefault,
+		Status:   http.StatusInternalServerError,
+		Instance: r.URL.Path,
+	}
+
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		p.Status = e.Status
+		p.Detail = e.Detail
+	case errors.Is(err, ErrBodyTooLarge), errors.Is(err, ErrDecompressedTooLarge):
+		p.Status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrBodyTooSlow):
+		p.Status = http.StatusRequestTimeout
+	case errors.Is(err, context.DeadlineExceeded):
+		p.Status = http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		p.Status = http.StatusServiceUnavailable
+	}
+	p.Title = http.StatusText(p.Status)
+
+	fields := []zap.Field{zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Int("status", p.Status), zap.Error(err)}
+	if p.Status >= http.StatusInternalServerError {
+		s.Error("Handle request fail", fields...)
+	} else {
+		s.Debug("Handle request fail", fields...)
+	}
+
+	w.Header().Set(headerContentType, ContentTypeProblemJSON)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// JSONHandler adapts fn to an http.Handler, fn must be one of
+//
+//	func(r *http.Request) (out T, err error)
+//	func(r *http.Request, in *In) (out T, err error)
+//	func(r *http.Request) error
+//	func(r *http.Request, in *In) error
+//
+// in is decoded by DecodeJSON with Config.MaxJSONBodySize, out is written by WriteJSON with 200 or
+// StatusCode() if it implements StatusCoder, nil out or no out responds 204, err is written by WriteError.
+// It panics if fn has another signature.
+func (s *HttpD) JSONHandler(fn interface{}) http.Handler {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+
+	if ft.Kind() != reflect.Func ||
+		ft.NumIn() < 1 || ft.NumIn() > 2 || ft.In(0) != typeRequest ||
+		ft.NumIn() == 2 && ft.In(1).Kind() != reflect.Ptr ||
+		ft.NumOut() < 1 || ft.NumOut() > 2 || ft.Out(ft.NumOut()-1) != typeError {
+		panic(fmt.Sprintf("httpd: invalid JSONHandler signature: %s", ft))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := []reflect.Value{reflect.ValueOf(r)}
+		if ft.NumIn() == 2 {
+			in := reflect.New(ft.In(1).Elem())
+			err := DecodeJSON(r, in.Interface(), s.config.MaxJSONBodySize)
+			if err != nil {
+				s.WriteError(w, r, err)
+				return
+			}
+			args = append(args, in)
+		}
+
+		outs := fv.Call(args)
+		if errV := outs[len(outs)-1]; !errV.IsNil() {
+			s.WriteError(w, r, errV.Interface().(error))
+			return
+		}
+
+		if len(outs) == 1 || isNil(outs[0]) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		out := outs[0].Interface()
+		status := http.StatusOK
+		if sc, ok := out.(StatusCoder); ok {
+			status = sc.StatusCode()
+		}
+		WriteJSON(w, status, out)
+	})
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,103 @@
+package httpd
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type echoReq struct {
+	Name string `json:"name"`
+}
+
+func (e *echoReq) Validate() error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+type echoResp struct {
+	Hello string `json:"hello"`
+}
+
+func serveJSON(h http.Handler, body string) (*httptest.ResponseRecorder, *Problem) {
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Type") != ContentTypeProblemJSON {
+		return w, nil
+	}
+	p := &Problem{}
+	_ = json.Unmarshal(w.Body.Bytes(), p)
+	return w, p
+}
+
+func TestHttpD_JSONHandler(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.MaxJSONBodySize = 32
+	s := New(c)
+
+	h := s.JSONHandler(func(r *http.Request, in *echoReq) (*echoResp, error) {
+		switch in.Name {
+		case "teapot":
+			return nil, Errorf(http.StatusTeapot, "I'm a teapot")
+		case "boom":
+			return nil, errors.New("internal detail")
+		case "nobody":
+			return nil, nil
+		}
+		return &echoResp{Hello: in.Name}, nil
+	})
+
+	w, _ := serveJSON(h, `{"name":"gtil"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"hello":"gtil"}`, w.Body.String())
+
+	w, _ = serveJSON(h, `{"name":"nobody"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	_, p := serveJSON(h, `{"name":"gtil","age":1}`)
+	assert.Equal(t, http.StatusBadRequest, p.Status, "unknown field should be rejected")
+
+	_, p = serveJSON(h, `{}`)
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, "name is required", p.Detail)
+
+	_, p = serveJSON(h, `{"name":"`+strings.Repeat("a", 64)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, p.Status)
+
+	_, p = serveJSON(h, `{"name":"teapot"}`)
+	assert.Equal(t, http.StatusTeapot, p.Status)
+	assert.Equal(t, "I'm a teapot", p.Detail)
+	assert.Equal(t, "/echo", p.Instance)
+
+	_, p = serveJSON(h, `{"name":"boom"}`)
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Empty(t, p.Detail, "internal error detail should not be sent")
+}
+
+func TestHttpD_JSONHandlerSignature(t *testing.T) {
+	s := New(NewConfig("127.0.0.1:0"))
+
+	assert.Panics(t, func() { s.JSONHandler(func(in *echoReq) error { return nil }) })
+	assert.Panics(t, func() { s.JSONHandler(func(r *http.Request) *echoResp { return nil }) })
+	assert.NotPanics(t, func() { s.JSONHandler(func(r *http.Request) error { return nil }) })
+}
+
+func TestDecodeJSON_DecompressedTooLarge(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Body = &limitedBody{ReadCloser: ioutil.NopCloser(strings.NewReader(`{"name":"too long"}`)), remain: 4, err: ErrDecompressedTooLarge}
+
+	err := DecodeJSON(r, &echoReq{}, 0)
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, e.Status)
+}
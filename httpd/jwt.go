@@ -0,0 +1,294 @@
+package httpd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	// register hash functions used by crypto.Hash
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	ErrInvalidToken = errors.New("Invalid token")
+	ErrTokenExpired = errors.New("Token expired")
+	ErrUnknownKey   = errors.New("Unknown token key")
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtKey is a public key of the JWKS, alg is the algorithm the key is restricted to, empty for any.
+type jwtKey struct {
+	alg string
+	key interface{}
+}
+
+type jwtAuthenticator struct {
+	keys     map[string]*jwtKey
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+// NewJWTAuthenticator accepts bearer tokens signed by a key of the JWKS file, supporting RS*, PS*, ES* and HS*.
+// issuer and audience are checked if not empty, leeway tolerates clock skew on exp and nbf.
+func NewJWTAuthenticator(jwksFile string, issuer string, audience string, leeway time.Duration) (Authenticator, error) {
+	bs, err := ioutil.ReadFile(jwksFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrLoadJWKS, jwksFile)
+	}
+
+	keys, err := parseJWKS(bs)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrLoadJWKS, jwksFile)
+	}
+
+	return &jwtAuthenticator{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   leeway,
+	}, nil
+}
+
+func parseJWKS(bs []byte) (map[string]*jwtKey, error) {
+	set := struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	err := json.Unmarshal(bs, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*jwtKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, ErrParseJWK, k.Kid)
+		}
+		keys[k.Kid] = &jwtKey{alg: k.Alg, key: key}
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf(ErrUnsupportedCurve, k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	default:
+		return nil, errors.Errorf(ErrUnsupportedKty, k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bs), nil
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	auth := r.Header.Get(headerAuthorization)
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil, nil
+	}
+
+	claims, err := a.verify(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return &Principal{Name: sub, Method: AuthMethodJWT, Claims: claims}, nil
+}
+
+func (a *jwtAuthenticator) Challenge() string {
+	return "Bearer"
+}
+
+func (a *jwtAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	header := &jwtHeader{}
+	err := decodeSegment(parts[0], header)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !verifySignature(header.Alg, key.key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+
+	claims := make(map[string]interface{})
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, a.validateClaims(claims)
+}
+
+func (a *jwtAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(a.leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return ErrInvalidToken
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return ErrInvalidToken
+	}
+	if a.audience != "" && !hasAudience(claims["aud"], a.audience) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+func verifySignature(alg string, key interface{}, signed []byte, sig []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(pub, hash, digest, sig, nil) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	default:
+		return false
+	}
+}
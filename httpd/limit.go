@@ -0,0 +1,213 @@
+package httpd
+
+import (
+	"github.com/donkeywon/gtil/statistics"
+	"golang.org/x/time/rate"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LimitKeyGlobal       = ""
+	LimitKeyIP           = "ip"
+	LimitKeyHeaderPrefix = "header:"
+
+	StatRateLimited        = "rateLimited"
+	StatConcurrencyLimited = "concurrencyLimited"
+
+	limitKeyIdleTTL       = 10 * time.Minute
+	limitKeySweepInterval = time.Minute
+)
+
+type limitRule struct {
+	config *LimitConfig
+
+	global *rate.Limiter
+
+	mu        sync.Mutex
+	keyed     map[string]*keyedLimiter
+	lastSweep time.Time
+
+	inFlight chan struct{}
+}
+
+type keyedLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newLimitRule(c *LimitConfig) *limitRule {
+	lr := &limitRule{
+		config: c,
+	}
+
+	if c.Rate > 0 {
+		if c.KeyBy == LimitKeyGlobal {
+			lr.global = rate.NewLimiter(rate.Limit(c.Rate), c.burst())
+		} else {
+			lr.keyed = make(map[string]*keyedLimiter)
+			lr.lastSweep = time.Now()
+		}
+	}
+	if c.MaxInFlight > 0 {
+		lr.inFlight = make(chan struct{}, c.MaxInFlight)
+	}
+
+	return lr
+}
+
+func (lr *limitRule) match(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, lr.config.PathPrefix)
+}
+
+func (lr *limitRule) limiter(r *http.Request) *rate.Limiter {
+	if lr.global != nil {
+		return lr.global
+	}
+	if lr.keyed == nil {
+		return nil
+	}
+
+	key := lr.key(r)
+	now := time.Now()
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	if now.Sub(lr.lastSweep) > limitKeySweepInterval {
+		for k, l := range lr.keyed {
+			if now.Sub(l.lastSeen) > limitKeyIdleTTL {
+				delete(lr.keyed, k)
+			}
+		}
+		lr.lastSweep = now
+	}
+
+	l, ok := lr.keyed[key]
+	if !ok {
+		l = &keyedLimiter{Limiter: rate.NewLimiter(rate.Limit(lr.config.Rate), lr.config.burst())}
+		lr.keyed[key] = l
+	}
+	l.lastSeen = now
+
+	return l.Limiter
+}
+
+func (lr *limitRule) key(r *http.Request) string {
+	if lr.config.KeyBy == LimitKeyIP {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+	if strings.HasPrefix(lr.config.KeyBy, LimitKeyHeaderPrefix) {
+		return r.Header.Get(lr.config.KeyBy[len(LimitKeyHeaderPrefix):])
+	}
+	return ""
+}
+
+// allow returns the time to wait before the request would be allowed, 0 means allowed.
+func (lr *limitRule) allow(r *http.Request) time.Duration {
+	l := lr.limiter(r)
+	if l == nil {
+		return 0
+	}
+
+	res := l.Reserve()
+	if !res.OK() {
+		return time.Second
+	}
+	delay := res.Delay()
+	if delay > 0 {
+		res.Cancel()
+	}
+	return delay
+}
+
+// acquire waits at most QueueTimeout for a free slot, the returned func releases it.
+func (lr *limitRule) acquire(r *http.Request) (func(), bool) {
+	if lr.inFlight == nil {
+		return func() {}, true
+	}
+
+	select {
+	case lr.inFlight <- struct{}{}:
+		return lr.release, true
+	default:
+	}
+
+	timeout := lr.config.QueueTimeout.ToDuration()
+	if timeout <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case lr.inFlight <- struct{}{}:
+		return lr.release, true
+	case <-timer.C:
+		return nil, false
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+func (lr *limitRule) release() {
+	<-lr.inFlight
+}
+
+type limitHandler struct {
+	next  http.Handler
+	rules []*limitRule
+	stats statistics.Statistics
+}
+
+func newLimitRules(configs []*LimitConfig) []*limitRule {
+	rules := make([]*limitRule, 0, len(configs))
+	for _, c := range configs {
+		rules = append(rules, newLimitRule(c))
+	}
+	return rules
+}
+
+func (h *limitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, rule := range h.rules {
+		if !rule.match(r) {
+			continue
+		}
+
+		if delay := rule.allow(r); delay > 0 {
+			h.stats.Incr(StatRateLimited, 1)
+			w.Header().Set("Retry-After", retryAfter(delay))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		release, ok := rule.acquire(r)
+		if !ok {
+			h.stats.Incr(StatConcurrencyLimited, 1)
+			w.Header().Set("Retry-After", retryAfter(rule.config.QueueTimeout.ToDuration()))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+func retryAfter(d time.Duration) string {
+	sec := int(math.Ceil(d.Seconds()))
+	if sec < 1 {
+		sec = 1
+	}
+	return strconv.Itoa(sec)
+}
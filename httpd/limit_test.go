@@ -0,0 +1,58 @@
+package httpd
+
+import (
+	"github.com/donkeywon/gtil/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveLimit(h http.Handler, path string, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestLimitHandler_Rate(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.Limits = []*LimitConfig{{PathPrefix: "/api", Rate: 0.5, Burst: 1, KeyBy: LimitKeyIP}}
+	h := New(c)
+	handler := h.wrap(newTextRouter("ok"))
+
+	assert.Equal(t, http.StatusNotFound, serveLimit(handler, "/api", "1.1.1.1:1").Code)
+	w := serveLimit(handler, "/api", "1.1.1.1:2")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusNotFound, serveLimit(handler, "/api", "2.2.2.2:1").Code, "other ip should not be limited")
+	assert.Equal(t, http.StatusOK, serveLimit(handler, "/", "1.1.1.1:3").Code, "unmatched path should not be limited")
+
+	assert.Equal(t, float64(1), h.Statistics()[StatRateLimited])
+}
+
+func TestLimitHandler_InFlight(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.Limits = []*LimitConfig{{MaxInFlight: 1, QueueTimeout: config.Duration(10 * time.Millisecond)}}
+	h := New(c)
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	handler := h.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-block
+	}))
+
+	go serveLimit(handler, "/", "1.1.1.1:1")
+	<-started
+
+	w := serveLimit(handler, "/", "1.1.1.1:2")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, float64(1), h.Statistics()[StatConcurrencyLimited])
+
+	close(block)
+}
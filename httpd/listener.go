@@ -0,0 +1,164 @@
+package httpd
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NetworkTCP  = "tcp"
+	NetworkTCP4 = "tcp4"
+	NetworkTCP6 = "tcp6"
+	NetworkUnix = "unix"
+	NetworkFd   = "fd"
+
+	systemdListenFdsStart = 3
+)
+
+func listen(lc *ListenerConfig) (net.Listener, error) {
+	switch lc.Network {
+	case NetworkTCP, NetworkTCP4, NetworkTCP6:
+		return net.Listen(lc.Network, lc.Addr)
+	case NetworkUnix:
+		return listenUnix(lc)
+	case NetworkFd:
+		return listenFd(lc.Addr)
+	default:
+		return nil, errors.Errorf(ErrUnknownNetwork, lc.Network)
+	}
+}
+
+func listenUnix(lc *ListenerConfig) (net.Listener, error) {
+	err := removeStaleSocket(lc.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen(NetworkUnix, lc.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.FileMode != "" {
+		var mode uint64
+		mode, err = strconv.ParseUint(lc.FileMode, 8, 32)
+		if err == nil {
+			err = os.Chmod(lc.Addr, os.FileMode(mode))
+		}
+	}
+	if err == nil && lc.Owner != "" {
+		err = chown(lc.Addr, lc.Owner)
+	}
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// removeStaleSocket removes a socket file left by a previous process, but refuses to
+// remove anything which is not a socket or which still accepts connections.
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf(ErrNotSocket, path)
+	}
+
+	conn, err := net.DialTimeout(NetworkUnix, path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return errors.Errorf(ErrSocketInUse, path)
+	}
+
+	return os.Remove(path)
+}
+
+func chown(path string, owner string) error {
+	uid, gid := -1, -1
+	parts := strings.SplitN(owner, ":", 2)
+
+	if parts[0] != "" {
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			u, err := user.Lookup(parts[0])
+			if err != nil {
+				return err
+			}
+			id, err = strconv.Atoi(u.Uid)
+			if err != nil {
+				return err
+			}
+		}
+		uid = id
+	}
+
+	if len(parts) == 2 && parts[1] != "" {
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			g, err := user.LookupGroup(parts[1])
+			if err != nil {
+				return err
+			}
+			id, err = strconv.Atoi(g.Gid)
+			if err != nil {
+				return err
+			}
+		}
+		gid = id
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// listenFd wraps an inherited file descriptor, addr is either the fd number or
+// a name from LISTEN_FDNAMES when started by systemd socket activation.
+func listenFd(addr string) (net.Listener, error) {
+	fd, err := strconv.Atoi(addr)
+	if err != nil {
+		fd, err = systemdFdByName(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f := os.NewFile(uintptr(fd), "fd:"+addr)
+	if f == nil {
+		return nil, errors.Errorf(ErrInvalidFd, addr)
+	}
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+func systemdFdByName(name string) (int, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return 0, errors.Errorf(ErrInvalidFd, name)
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return 0, errors.Errorf(ErrInvalidFd, name)
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n && i < len(names); i++ {
+		if names[i] == name {
+			return systemdListenFdsStart + i, nil
+		}
+	}
+
+	return 0, errors.Errorf(ErrInvalidFd, name)
+}
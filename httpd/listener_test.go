@@ -0,0 +1,85 @@
+package httpd
+
+import (
+	"context"
+	"github.com/donkeywon/gtil/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTextRouter(text string) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(text))
+	})
+	return r
+}
+
+func TestHttpD_MultiListener(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "httpd.sock")
+
+	c := NewConfig("")
+	admin := NewListenerConfig(NetworkTCP, "127.0.0.1:0")
+	admin.Handler = "admin"
+	c.Listeners = []*ListenerConfig{
+		NewListenerConfig(NetworkTCP, "127.0.0.1:0"),
+		admin,
+		NewListenerConfig(NetworkUnix, sock),
+	}
+
+	h := New(c)
+	h.SetHandler(newTextRouter("traffic"))
+	h.SetNamedHandler("admin", newTextRouter("admin"))
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	addrs := h.Addrs()
+	assert.Len(t, addrs, 3)
+
+	fi, err := os.Stat(sock)
+	assert.NoError(t, err, "stat socket fail")
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+
+	assert.Equal(t, "traffic", get(t, http.DefaultClient, "http://"+addrs[0].String()+"/"))
+	assert.Equal(t, "admin", get(t, http.DefaultClient, "http://"+addrs[1].String()+"/"))
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(NetworkUnix, sock)
+		},
+	}}
+	assert.Equal(t, "traffic", get(t, unixClient, "http://unix/"))
+}
+
+func TestHttpD_OpenFailCloseOpened(t *testing.T) {
+	c := NewConfig("")
+	c.Listeners = []*ListenerConfig{
+		NewListenerConfig(NetworkTCP, "127.0.0.1:0"),
+		NewListenerConfig("sctp", "127.0.0.1:0"),
+	}
+
+	h := New(c)
+	err := service.DoOpen(h, context.Background(), log)
+	assert.Error(t, err, "unknown network should fail")
+	assert.Empty(t, h.Addrs(), "opened listener not closed")
+}
+
+func get(t *testing.T, c *http.Client, url string) string {
+	resp, err := c.Get(url)
+	if !assert.NoError(t, err, "request fail") {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err, "read body fail")
+	return string(body)
+}
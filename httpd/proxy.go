@@ -0,0 +1,224 @@
+package httpd
+
+import (
+	"context"
+	"github.com/donkeywon/gtil/httpc"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BalanceRoundRobin = "roundRobin"
+	BalanceLeastConns = "leastConns"
+
+	StatProxyEjected = "proxyEjected"
+)
+
+type upstreamKey struct{}
+
+type upstream struct {
+	url    *url.URL
+	active atomic.Int64
+
+	mu           sync.Mutex
+	fails        int
+	ejectedUntil time.Time
+}
+
+func (u *upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !now.Before(u.ejectedUntil)
+}
+
+type proxyHandler struct {
+	config    *ProxyConfig
+	owner     *HttpD
+	upstreams []*upstream
+	next      atomic.Uint64
+	proxy     *httputil.ReverseProxy
+}
+
+// ProxyHandler forwards requests to the upstreams of c through client, so the connection pool and timeouts
+// of its httpc.Config apply. Request and response bodies are streamed, requests are neither retried nor hedged. An upstream failing MaxFails times in a
+// row, by connection error or 502, 503, 504, is ejected for EjectDuration, if all are ejected all are tried.
+func (s *HttpD) ProxyHandler(client *httpc.HttpC, c *ProxyConfig) (http.Handler, error) {
+	if len(c.Upstreams) == 0 {
+		return nil, errors.New(ErrNoUpstream)
+	}
+
+	h := &proxyHandler{
+		config: c,
+		owner:  s,
+	}
+	for _, raw := range c.Upstreams {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, ErrInvalidUpstream, raw)
+		}
+		h.upstreams = append(h.upstreams, &upstream{url: u})
+	}
+
+	h.proxy = &httputil.ReverseProxy{
+		Director:       h.direct,
+		Transport:      client.RoundTripper(),
+		FlushInterval:  c.FlushInterval.ToDuration(),
+		ModifyResponse: h.modifyResponse,
+		ErrorHandler:   h.handleError,
+	}
+
+	return h, nil
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := h.pick()
+	u.active.Inc()
+	defer u.active.Dec()
+
+	ctx := httpc.WithStream(context.WithValue(r.Context(), upstreamKey{}, u))
+	h.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (h *proxyHandler) pick() *upstream {
+	now := time.Now()
+	candidates := make([]*upstream, 0, len(h.upstreams))
+	for _, u := range h.upstreams {
+		if u.available(now) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = h.upstreams
+	}
+
+	if h.config.Balance == BalanceLeastConns {
+		best := candidates[0]
+		for _, u := range candidates[1:] {
+			if u.active.Load() < best.active.Load() {
+				best = u
+			}
+		}
+		return best
+	}
+
+	return candidates[(h.next.Inc()-1)%uint64(len(candidates))]
+}
+
+func (h *proxyHandler) direct(req *http.Request) {
+	u := req.Context().Value(upstreamKey{}).(*upstream)
+
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		if req.TLS != nil {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			req.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+
+	p := stripPrefix(req.URL.Path, h.config.StripPrefix)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	p = h.config.AddPrefix + p
+
+	req.URL.Scheme = u.url.Scheme
+	req.URL.Host = u.url.Host
+	req.URL.Path = strings.TrimSuffix(u.url.Path, "/") + p
+	req.URL.RawPath = ""
+	if u.url.RawQuery != "" {
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = u.url.RawQuery
+		} else {
+			req.URL.RawQuery = u.url.RawQuery + "&" + req.URL.RawQuery
+		}
+	}
+	if !h.config.PreserveHost {
+		req.Host = ""
+	}
+	// the request is sent by a transport, not by http.Client
+	req.RequestURI = ""
+
+	for _, k := range h.config.RemoveHeaders {
+		req.Header.Del(k)
+	}
+	for k, v := range h.config.SetHeaders {
+		req.Header.Set(k, v)
+	}
+}
+
+// stripPrefix removes prefix from path only on a segment boundary, /api strips /api and /api/x, not /apiv2/x.
+func stripPrefix(path string, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || !strings.HasPrefix(path, prefix) {
+		return path
+	}
+	if len(path) > len(prefix) && path[len(prefix)] != '/' {
+		return path
+	}
+	return path[len(prefix):]
+}
+
+func (h *proxyHandler) modifyResponse(resp *http.Response) error {
+	u := resp.Request.Context().Value(upstreamKey{}).(*upstream)
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		h.fail(u)
+	default:
+		h.succeed(u)
+	}
+
+	for _, k := range h.config.RemoveResponseHeaders {
+		resp.Header.Del(k)
+	}
+	for k, v := range h.config.SetResponseHeaders {
+		resp.Header.Set(k, v)
+	}
+	return nil
+}
+
+func (h *proxyHandler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	u := r.Context().Value(upstreamKey{}).(*upstream)
+	if !errors.Is(err, context.Canceled) {
+		h.fail(u)
+	}
+
+	h.owner.Warn("Proxy request fail", zap.String("upstream", u.url.String()), zap.String("path", r.URL.Path), zap.Error(err))
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+func (h *proxyHandler) fail(u *upstream) {
+	if h.config.MaxFails <= 0 {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.fails++
+	if u.fails >= h.config.MaxFails {
+		u.fails = 0
+		u.ejectedUntil = time.Now().Add(h.config.EjectDuration.ToDuration())
+		h.owner.stats.Incr(StatProxyEjected, 1)
+		h.owner.Warn("Upstream ejected", zap.String("upstream", u.url.String()))
+	}
+}
+
+func (h *proxyHandler) succeed(u *upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+}
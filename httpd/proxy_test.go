@@ -0,0 +1,92 @@
+package httpd
+
+import (
+	"github.com/donkeywon/gtil/config"
+	"github.com/donkeywon/gtil/httpc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newUpstream(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", name)
+		w.Header().Set("Server", "upstream")
+		_, _ = w.Write([]byte(name + " " + r.URL.Path + " " + r.Header.Get("X-Set") + r.Header.Get("X-Remove")))
+	}))
+}
+
+func TestHttpD_ProxyHandler(t *testing.T) {
+	a := newUpstream("a")
+	defer a.Close()
+	b := newUpstream("b")
+	defer b.Close()
+
+	c := NewProxyConfig(a.URL, b.URL+"/base")
+	c.StripPrefix = "/api"
+	c.AddPrefix = "/v1"
+	c.SetHeaders = map[string]string{"X-Set": "set"}
+	c.RemoveHeaders = []string{"X-Remove"}
+	c.RemoveResponseHeaders = []string{"Server"}
+	c.MaxFails = 1
+
+	h := New(NewConfig("127.0.0.1:0"))
+	client, err := httpc.New(httpc.NewConfig())
+	assert.NoError(t, err, "new httpc fail")
+	proxy, err := h.ProxyHandler(client, c)
+	assert.NoError(t, err, "new proxy fail")
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req.Header.Set("X-Remove", "remove")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve()
+	assert.Equal(t, "a /v1/users set", w.Body.String())
+	assert.Empty(t, w.Header().Get("Server"))
+	assert.Equal(t, "b /base/v1/users set", serve().Body.String())
+	assert.Equal(t, "a /v1/users set", serve().Body.String())
+
+	b.Close()
+	assert.Equal(t, http.StatusBadGateway, serve().Code)
+	assert.Equal(t, float64(1), h.Statistics()[StatProxyEjected])
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "a", serve().Header().Get("X-Upstream"), "ejected upstream should be skipped")
+	}
+
+	_, err = h.ProxyHandler(client, NewProxyConfig())
+	assert.Error(t, err, "no upstream should fail")
+}
+
+func TestHttpD_ProxyHandlerTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	hc := httpc.NewConfig()
+	hc.Timeout = config.Duration(50 * time.Millisecond)
+	client, err := httpc.New(hc)
+	assert.NoError(t, err, "new httpc fail")
+
+	h := New(NewConfig("127.0.0.1:0"))
+	proxy, err := h.ProxyHandler(client, NewProxyConfig(slow.URL))
+	assert.NoError(t, err, "new proxy fail")
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code, "httpc Timeout should apply to proxied requests")
+}
+
+func TestStripPrefix(t *testing.T) {
+	assert.Equal(t, "/x", stripPrefix("/api/x", "/api"))
+	assert.Equal(t, "/x", stripPrefix("/api/x", "/api/"))
+	assert.Equal(t, "", stripPrefix("/api", "/api"))
+	assert.Equal(t, "/apiv2/x", stripPrefix("/apiv2/x", "/api"), "prefix should end on a path segment")
+	assert.Equal(t, "/x", stripPrefix("/x", ""))
+}
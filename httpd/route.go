@@ -0,0 +1,143 @@
+package httpd
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// routeTable is the handler of listeners with the same handler name. The router and the
+// mounted handlers are swapped atomically, so requests in flight finish on the old ones.
+type routeTable struct {
+	mu      sync.Mutex
+	current atomic.Value // *routeSnapshot
+}
+
+type routeSnapshot struct {
+	router *mux.Router
+	mounts []*mount
+}
+
+type mount struct {
+	prefix  string
+	handler http.Handler
+}
+
+type RouteInfo struct {
+	Handler string   `json:"handler"`
+	Mount   string   `json:"mount,omitempty"`
+	Path    string   `json:"path"`
+	Methods []string `json:"methods,omitempty"`
+	Name    string   `json:"name,omitempty"`
+}
+
+func newRouteTable() *routeTable {
+	t := &routeTable{}
+	t.current.Store(&routeSnapshot{})
+	return t
+}
+
+func (t *routeTable) snapshot() *routeSnapshot {
+	return t.current.Load().(*routeSnapshot)
+}
+
+func (t *routeTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snap := t.snapshot()
+	for _, m := range snap.mounts {
+		if r.URL.Path == m.prefix || strings.HasPrefix(r.URL.Path, m.prefix+"/") {
+			m.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	if snap.router == nil {
+		http.NotFound(w, r)
+		return
+	}
+	snap.router.ServeHTTP(w, r)
+}
+
+func (t *routeTable) setRouter(router *mux.Router) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old := t.snapshot()
+	t.current.Store(&routeSnapshot{router: router, mounts: old.mounts})
+}
+
+func (t *routeTable) mount(prefix string, h http.Handler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	old := t.snapshot()
+	mounts := make([]*mount, 0, len(old.mounts)+1)
+	for _, m := range old.mounts {
+		if m.prefix != prefix {
+			mounts = append(mounts, m)
+		}
+	}
+	mounts = append(mounts, &mount{prefix: prefix, handler: h})
+	// longest prefix wins
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].prefix) > len(mounts[j].prefix)
+	})
+
+	t.current.Store(&routeSnapshot{router: old.router, mounts: mounts})
+}
+
+func (t *routeTable) unmount(prefix string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	old := t.snapshot()
+	mounts := make([]*mount, 0, len(old.mounts))
+	for _, m := range old.mounts {
+		if m.prefix != prefix {
+			mounts = append(mounts, m)
+		}
+	}
+
+	t.current.Store(&routeSnapshot{router: old.router, mounts: mounts})
+}
+
+func (t *routeTable) routes(name string) []*RouteInfo {
+	snap := t.snapshot()
+
+	var routes []*RouteInfo
+	for _, m := range snap.mounts {
+		router, ok := m.handler.(*mux.Router)
+		if !ok {
+			routes = append(routes, &RouteInfo{Handler: name, Mount: m.prefix, Path: m.prefix})
+			continue
+		}
+		routes = append(routes, walkRoutes(name, m.prefix, router)...)
+	}
+	if snap.router != nil {
+		routes = append(routes, walkRoutes(name, "", snap.router)...)
+	}
+	return routes
+}
+
+func walkRoutes(name string, mountPrefix string, router *mux.Router) []*RouteInfo {
+	var routes []*RouteInfo
+	_ = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		routes = append(routes, &RouteInfo{
+			Handler: name,
+			Mount:   mountPrefix,
+			Path:    tpl,
+			Methods: methods,
+			Name:    route.GetName(),
+		})
+		return nil
+	})
+	return routes
+}
@@ -0,0 +1,81 @@
+package httpd
+
+import (
+	"context"
+	"github.com/donkeywon/gtil/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestHttpD_HotSwap(t *testing.T) {
+	h := New(NewConfig("127.0.0.1:0"))
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	base := "http://" + h.Addr().String()
+
+	resp, err := http.Get(base + "/")
+	assert.NoError(t, err, "request fail")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "no router set")
+
+	h.SetHandler(newTextRouter("v1"))
+	assert.Equal(t, "v1", get(t, http.DefaultClient, base+"/"))
+
+	plugin := mux.NewRouter()
+	plugin.HandleFunc("/plugin/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("plugin"))
+	}).Methods(http.MethodGet).Name("hello")
+	h.Mount("/plugin/", plugin)
+	assert.Equal(t, "plugin", get(t, http.DefaultClient, base+"/plugin/hello"))
+
+	routes := h.Routes()
+	assert.Len(t, routes, 2)
+	assert.Equal(t, "/plugin", routes[0].Mount)
+	assert.Equal(t, "/plugin/hello", routes[0].Path)
+	assert.Equal(t, []string{http.MethodGet}, routes[0].Methods)
+	assert.Equal(t, "/", routes[1].Path)
+
+	h.Unmount("/plugin")
+	resp, err = http.Get(base + "/plugin/hello")
+	assert.NoError(t, err, "request fail")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "plugin should be unmounted")
+}
+
+func TestHttpD_SwapInFlight(t *testing.T) {
+	h := New(NewConfig("127.0.0.1:0"))
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	old := mux.NewRouter()
+	old.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("old"))
+	})
+	h.SetHandler(old)
+
+	var body string
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		body = get(t, http.DefaultClient, "http://"+h.Addr().String()+"/")
+	}()
+
+	<-started
+	h.SetHandler(newTextRouter("new"))
+	assert.Equal(t, "new", get(t, http.DefaultClient, "http://"+h.Addr().String()+"/"))
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, "old", body)
+}
@@ -0,0 +1,59 @@
+package httpd
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	headerStrictTransportSecurity = "Strict-Transport-Security"
+	headerContentTypeOptions      = "X-Content-Type-Options"
+	headerFrameOptions            = "X-Frame-Options"
+	headerContentSecurityPolicy   = "Content-Security-Policy"
+	headerReferrerPolicy          = "Referrer-Policy"
+)
+
+type secureHandler struct {
+	next    http.Handler
+	headers map[string]string
+}
+
+func newSecureHandler(next http.Handler, c *SecurityHeadersConfig) *secureHandler {
+	headers := make(map[string]string)
+
+	if c.HSTSMaxAge > 0 {
+		v := "max-age=" + strconv.Itoa(int(c.HSTSMaxAge.ToDuration().Seconds()))
+		if c.HSTSIncludeSubdomains {
+			v += "; includeSubDomains"
+		}
+		if c.HSTSPreload {
+			v += "; preload"
+		}
+		headers[headerStrictTransportSecurity] = v
+	}
+	if c.ContentTypeNosniff {
+		headers[headerContentTypeOptions] = "nosniff"
+	}
+	if c.FrameOptions != "" {
+		headers[headerFrameOptions] = c.FrameOptions
+	}
+	if c.ContentSecurityPolicy != "" {
+		headers[headerContentSecurityPolicy] = c.ContentSecurityPolicy
+	}
+	if c.ReferrerPolicy != "" {
+		headers[headerReferrerPolicy] = c.ReferrerPolicy
+	}
+
+	return &secureHandler{
+		next:    next,
+		headers: headers,
+	}
+}
+
+func (h *secureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	for k, v := range h.headers {
+		header.Set(k, v)
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,213 @@
+package httpd
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+
+	headerLastEventID = "Last-Event-ID"
+
+	sseClientBuffer = 64
+)
+
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// SSEHub broadcasts events to Server-Sent Events clients. The last ReplaySize events are kept
+// so reconnecting clients get what they missed after their Last-Event-ID. Clients too slow to
+// keep up are disconnected and expected to reconnect.
+type SSEHub struct {
+	config *SSEConfig
+	owner  *HttpD
+
+	mu      sync.Mutex
+	nextID  uint64
+	replay  []*Event
+	clients map[*sseClient]struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type sseClient struct {
+	events chan *Event
+	// dropped is closed when the client is too slow
+	dropped chan struct{}
+}
+
+// NewSSEHub creates a hub closed with s, which ends all its streams when s is closed or shut down.
+// Streams are long requests, serve them on a listener whose WriteTimeout is negative to disable it.
+func (s *HttpD) NewSSEHub(c *SSEConfig) *SSEHub {
+	h := &SSEHub{
+		config:  c,
+		owner:   s,
+		clients: make(map[*sseClient]struct{}),
+		closed:  make(chan struct{}),
+	}
+	s.track(h)
+	return h
+}
+
+// Publish sends an event to all clients and returns its id.
+func (h *SSEHub) Publish(event string, data string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	e := &Event{
+		ID:    strconv.FormatUint(h.nextID, 10),
+		Event: event,
+		Data:  data,
+	}
+
+	if h.config.ReplaySize > 0 {
+		if len(h.replay) >= h.config.ReplaySize {
+			h.replay = append(h.replay[:0], h.replay[len(h.replay)-h.config.ReplaySize+1:]...)
+		}
+		h.replay = append(h.replay, e)
+	}
+
+	for c := range h.clients {
+		select {
+		case c.events <- e:
+		default:
+			delete(h.clients, c)
+			close(c.dropped)
+		}
+	}
+
+	return e.ID
+}
+
+// Clients returns the number of connected clients.
+func (h *SSEHub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Close ends all streams, later requests get 503.
+func (h *SSEHub) Close() error {
+	h.once.Do(func() {
+		close(h.closed)
+		h.owner.untrack(h)
+	})
+	return nil
+}
+
+func (h *SSEHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	select {
+	case <-h.closed:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	c, missed := h.subscribe(r.Header.Get(headerLastEventID))
+	defer h.unsubscribe(c)
+
+	header := w.Header()
+	header.Set(headerContentType, ContentTypeEventStream)
+	header.Set(headerCacheControl, "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	buf := &bytes.Buffer{}
+	if h.config.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(h.config.Retry.ToDuration().Milliseconds(), 10) + "\n\n")
+	}
+	for _, e := range missed {
+		writeEvent(buf, e)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if h.config.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(h.config.HeartbeatInterval.ToDuration())
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		buf.Reset()
+		select {
+		case e := <-c.events:
+			writeEvent(buf, e)
+		case <-heartbeat:
+			buf.WriteString(": ping\n\n")
+		case <-c.dropped:
+			return
+		case <-r.Context().Done():
+			return
+		case <-h.closed:
+			return
+		}
+
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// subscribe registers a client and returns the buffered events after lastID,
+// all of them if lastID is not buffered anymore.
+func (h *SSEHub) subscribe(lastID string) (*sseClient, []*Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := &sseClient{
+		events:  make(chan *Event, sseClientBuffer),
+		dropped: make(chan struct{}),
+	}
+	h.clients[c] = struct{}{}
+
+	if lastID == "" {
+		return c, nil
+	}
+	for i, e := range h.replay {
+		if e.ID == lastID {
+			return c, append([]*Event(nil), h.replay[i+1:]...)
+		}
+	}
+	return c, append([]*Event(nil), h.replay...)
+}
+
+func (h *SSEHub) unsubscribe(c *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+func writeEvent(buf *bytes.Buffer, e *Event) {
+	buf.WriteString("id: " + e.ID + "\n")
+	if e.Event != "" {
+		buf.WriteString("event: " + e.Event + "\n")
+	}
+	// clients end a field at \r\n, \r or \n
+	data := strings.ReplaceAll(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,128 @@
+package httpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/donkeywon/gtil/config"
+	"github.com/donkeywon/gtil/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readEvent(r *bufio.Reader) string {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return strings.Join(lines, "|")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return strings.Join(lines, "|")
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestSSEHub(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.WriteTimeout = config.Duration(-1)
+	h := New(c)
+
+	sc := NewSSEConfig()
+	sc.ReplaySize = 2
+	sc.HeartbeatInterval = config.Duration(200 * time.Millisecond)
+	hub := h.NewSSEHub(sc)
+
+	r := mux.NewRouter()
+	r.Handle("/events", hub)
+	h.SetHandler(r)
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+
+	hub.Publish("", "one")
+	hub.Publish("", "two")
+	hub.Publish("tick", "three\nfour")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+h.Addr().String()+"/events", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "request fail")
+	defer resp.Body.Close()
+	assert.Equal(t, ContentTypeEventStream, resp.Header.Get("Content-Type"))
+
+	br := bufio.NewReader(resp.Body)
+	assert.Equal(t, "id: 3|event: tick|data: three|data: four", readEvent(br))
+
+	hub.Publish("", "five")
+	assert.Equal(t, "id: 4|data: five", readEvent(br))
+	assert.Equal(t, ": ping", readEvent(br))
+	assert.Equal(t, 1, hub.Clients())
+
+	done := make(chan struct{})
+	go func() {
+		_ = service.DoShutdown(h)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown blocked by open stream")
+	}
+	_, err = br.ReadString('\n')
+	for err == nil {
+		_, err = br.ReadString('\n')
+	}
+}
+
+func TestWriteEvent_LineBreaks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writeEvent(buf, &Event{ID: "1", Data: "a\r\nb\rc\nd"})
+	assert.Equal(t, "id: 1\ndata: a\ndata: b\ndata: c\ndata: d\n\n", buf.String())
+}
+
+func TestSSEHub_Compression(t *testing.T) {
+	c := NewConfig("127.0.0.1:0")
+	c.WriteTimeout = config.Duration(-1)
+	c.Compression = NewCompressionConfig()
+	h := New(c)
+
+	hub := h.NewSSEHub(NewSSEConfig())
+	r := mux.NewRouter()
+	r.Handle("/events", hub)
+	h.SetHandler(r)
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+h.Addr().String()+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "request fail")
+	defer resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("Content-Encoding"), "event stream should not be compressed")
+
+	received := make(chan string, 1)
+	go func() {
+		received <- readEvent(bufio.NewReader(resp.Body))
+	}()
+	for hub.Clients() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.Publish("", "one")
+
+	select {
+	case event := <-received:
+		assert.Equal(t, "id: 1|data: one", event)
+	case <-time.After(time.Second):
+		t.Fatal("event held back by compression")
+	}
+}
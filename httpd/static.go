@@ -0,0 +1,181 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerCacheControl = "Cache-Control"
+	headerETag         = "ETag"
+
+	precompressedExt = ".gz"
+)
+
+type staticHandler struct {
+	fsys   fs.FS
+	config *StaticConfig
+	lister http.Handler
+
+	etags sync.Map
+}
+
+type staticETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// NewStaticHandler serves files of fsys, which may be an embed.FS. Mount it with MountStatic
+// or http.StripPrefix so the request path is relative to the root of fsys.
+func NewStaticHandler(fsys fs.FS, c *StaticConfig) http.Handler {
+	return &staticHandler{
+		fsys:   fsys,
+		config: c,
+		lister: http.FileServer(http.FS(fsys)),
+	}
+}
+
+func NewStaticDirHandler(dir string, c *StaticConfig) http.Handler {
+	return NewStaticHandler(os.DirFS(dir), c)
+}
+
+// MountStatic serves fsys under prefix of r.
+func MountStatic(r *mux.Router, prefix string, fsys fs.FS, c *StaticConfig) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	r.PathPrefix(prefix + "/").Handler(http.StripPrefix(prefix, NewStaticHandler(fsys, c)))
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	fi, err := fs.Stat(h.fsys, name)
+	if err == nil && fi.IsDir() {
+		index := path.Join(name, h.config.Index)
+		ifi, err := fs.Stat(h.fsys, index)
+		if err == nil && !ifi.IsDir() {
+			h.serveFile(w, r, index, ifi)
+			return
+		}
+		if h.config.DirectoryListing {
+			h.lister.ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		h.serveFile(w, r, name, fi)
+		return
+	}
+
+	if h.config.SPAFallback && path.Ext(name) == "" {
+		fi, err = fs.Stat(h.fsys, h.config.Index)
+		if err == nil && !fi.IsDir() {
+			h.serveFile(w, r, h.config.Index, fi)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, fi fs.FileInfo) {
+	header := w.Header()
+	if path.Base(name) == h.config.Index {
+		header.Set(headerCacheControl, "no-cache")
+	} else if h.config.MaxAge > 0 {
+		header.Set(headerCacheControl, "public, max-age="+strconv.Itoa(int(h.config.MaxAge.ToDuration().Seconds())))
+	}
+
+	servedName := name
+	if h.config.Precompressed {
+		header.Add(headerVary, headerAcceptEncoding)
+		if negotiateEncoding(r.Header.Get(headerAcceptEncoding)) == EncodingGzip {
+			gzfi, err := fs.Stat(h.fsys, name+precompressedExt)
+			if err == nil && !gzfi.IsDir() {
+				servedName, fi = name+precompressedExt, gzfi
+				header.Set(headerContentEncoding, EncodingGzip)
+			}
+		}
+	}
+
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		header.Set(headerContentType, ct)
+	}
+
+	f, err := h.fsys.Open(servedName)
+	if err != nil {
+		header.Del(headerContentEncoding)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		bs, err := io.ReadAll(f)
+		if err != nil {
+			header.Del(headerContentEncoding)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(bs)
+	}
+
+	etag, err := h.etag(servedName, fi, content)
+	if err == nil {
+		header.Set(headerETag, etag)
+	}
+
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// etag hashes the content once per name, size and modification time,
+// as files of embed.FS have no modification time.
+func (h *staticHandler) etag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if v, ok := h.etags.Load(name); ok {
+		e := v.(*staticETag)
+		if e.modTime.Equal(fi.ModTime()) && e.size == fi.Size() {
+			return e.etag, nil
+		}
+	}
+
+	hash := sha256.New()
+	_, err := io.Copy(hash, content)
+	if err != nil {
+		return "", err
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	e := &staticETag{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+		etag:    `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`,
+	}
+	h.etags.Store(name, e)
+	return e.etag, nil
+}
@@ -0,0 +1,61 @@
+package httpd
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func serveStatic(h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestMountStatic(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":   {Data: []byte("<html>index</html>")},
+		"app.js":       {Data: []byte("console.log(1)")},
+		"app.js.gz":    {Data: []byte("gzipped")},
+		"assets/a.css": {Data: []byte("body{}")},
+	}
+
+	c := NewStaticConfig()
+	c.SPAFallback = true
+	r := mux.NewRouter()
+	MountStatic(r, "/ui", fsys, c)
+
+	w := serveStatic(r, "/ui/assets/a.css", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "body{}", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/css")
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	w = serveStatic(r, "/ui/assets/a.css", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = serveStatic(r, "/ui/app.js", http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, "gzipped", w.Body.String())
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+
+	w = serveStatic(r, "/ui/app.js", nil)
+	assert.Equal(t, "console.log(1)", w.Body.String())
+
+	w = serveStatic(r, "/ui/orders/1", nil)
+	assert.Equal(t, "<html>index</html>", w.Body.String(), "spa fallback")
+
+	w = serveStatic(r, "/ui/missing.png", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serveStatic(r, "/ui/assets/", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "directory listing should be disabled")
+}
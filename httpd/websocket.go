@@ -0,0 +1,230 @@
+package httpd
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatWebSocketConns  = "webSocketConns"
+	StatWebSocketOpened = "webSocketOpened"
+
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+)
+
+var (
+	ErrWSQueueFull = errors.New("WebSocket write queue full")
+	ErrWSClosed    = errors.New("WebSocket closed")
+)
+
+type wsMessage struct {
+	messageType int
+	data        []byte
+}
+
+// WSConn is a WebSocket connection tracked by its HttpD. Send is safe for concurrent use,
+// ReadMessage must only be called by the handler goroutine.
+type WSConn struct {
+	conn   *websocket.Conn
+	config *WebSocketConfig
+	owner  *HttpD
+
+	// sendMu orders Send against cancel, so no message is queued after writeLoop drained the queue
+	sendMu sync.RWMutex
+	queue  chan *wsMessage
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	done   chan struct{}
+}
+
+// WebSocketHandler upgrades requests to WebSocket and runs fn with the connection, which is closed
+// with a normal close frame when fn returns, or a going away close frame when s closes or shuts down.
+func (s *HttpD) WebSocketHandler(c *WebSocketConfig, fn func(conn *WSConn, r *http.Request)) http.Handler {
+	upgrader := &websocket.Upgrader{
+		HandshakeTimeout:  c.HandshakeTimeout.ToDuration(),
+		EnableCompression: c.EnableCompression,
+		CheckOrigin:       checkWSOrigin(c.AllowedOrigins),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has responded the error
+			s.Debug("Upgrade websocket fail", zap.String("path", r.URL.Path), zap.Error(err))
+			return
+		}
+
+		wc := s.newWSConn(conn, c)
+		defer wc.closeWith(websocket.CloseNormalClosure, "")
+
+		go wc.writeLoop()
+		fn(wc, r)
+	})
+}
+
+func (s *HttpD) newWSConn(conn *websocket.Conn, c *WebSocketConfig) *WSConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	wc := &WSConn{
+		conn:   conn,
+		config: c,
+		owner:  s,
+		queue:  make(chan *wsMessage, c.WriteQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	conn.SetReadLimit(c.MaxMessageSize)
+	if c.PongTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(c.PongTimeout.ToDuration()))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(c.PongTimeout.ToDuration()))
+		})
+	}
+
+	s.track(wc)
+	s.stats.Incr(StatWebSocketConns, 1)
+	s.stats.Incr(StatWebSocketOpened, 1)
+	return wc
+}
+
+// Context is canceled when the connection is closed.
+func (c *WSConn) Context() context.Context {
+	return c.ctx
+}
+
+// Send queues a message, it fails with ErrWSQueueFull instead of blocking on slow clients.
+func (c *WSConn) Send(messageType int, data []byte) error {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	select {
+	case <-c.ctx.Done():
+		return ErrWSClosed
+	default:
+	}
+
+	select {
+	case c.queue <- &wsMessage{messageType: messageType, data: data}:
+		return nil
+	default:
+		return ErrWSQueueFull
+	}
+}
+
+func (c *WSConn) ReadMessage() (int, []byte, error) {
+	return c.conn.ReadMessage()
+}
+
+func (c *WSConn) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+// Close sends a going away close frame and closes the connection.
+func (c *WSConn) Close() error {
+	c.closeWith(websocket.CloseGoingAway, "server closing")
+	return nil
+}
+
+// closeWith sends the close frame after the messages already queued, which writeLoop drains.
+func (c *WSConn) closeWith(code int, text string) {
+	c.once.Do(func() {
+		c.sendMu.Lock()
+		c.cancel()
+		c.sendMu.Unlock()
+		<-c.done
+
+		deadline := time.Now().Add(c.config.WriteTimeout.ToDuration())
+		_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+		_ = c.conn.Close()
+
+		c.owner.untrack(c)
+		c.owner.stats.Incr(StatWebSocketConns, -1)
+	})
+}
+
+// writeLoop is the only writer of data messages, it also sends pings.
+func (c *WSConn) writeLoop() {
+	defer close(c.done)
+
+	var ping <-chan time.Time
+	if c.config.PingInterval > 0 {
+		ticker := time.NewTicker(c.config.PingInterval.ToDuration())
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case m := <-c.queue:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout.ToDuration()))
+			err := c.conn.WriteMessage(m.messageType, m.data)
+			if err != nil {
+				c.cancel()
+				_ = c.conn.Close()
+				return
+			}
+		case <-ping:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.config.WriteTimeout.ToDuration()))
+			if err != nil {
+				c.cancel()
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.ctx.Done():
+			c.drain()
+			return
+		}
+	}
+}
+
+// drain writes the messages left in the queue within one WriteTimeout.
+func (c *WSConn) drain() {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout.ToDuration()))
+	for {
+		select {
+		case m := <-c.queue:
+			if err := c.conn.WriteMessage(m.messageType, m.data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// checkWSOrigin allows same origin requests when allowed is empty, like the default of websocket.Upgrader.
+func checkWSOrigin(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		return nil
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get(headerOrigin)
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		origin = strings.ToLower(origin)
+		for _, pattern := range allowed {
+			if pattern == "*" || matchOrigin(strings.ToLower(pattern), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
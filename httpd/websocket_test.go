@@ -0,0 +1,93 @@
+package httpd
+
+import (
+	"context"
+	"github.com/donkeywon/gtil/service"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHttpD_WebSocketHandler(t *testing.T) {
+	h := New(NewConfig("127.0.0.1:0"))
+
+	wc := NewWebSocketConfig()
+	wc.MaxMessageSize = 16
+	r := mux.NewRouter()
+	r.Handle("/ws", h.WebSocketHandler(wc, func(conn *WSConn, r *http.Request) {
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.Send(mt, data)
+		}
+	}))
+	h.SetHandler(r)
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+
+	url := "ws://" + h.Addr().String() + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err, "dial fail")
+	defer conn.Close()
+
+	err = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	assert.NoError(t, err, "write fail")
+	_, data, err := conn.ReadMessage()
+	assert.NoError(t, err, "read fail")
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, float64(1), h.Statistics()[StatWebSocketConns])
+
+	big, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err, "dial fail")
+	_ = big.WriteMessage(websocket.TextMessage, make([]byte, 32))
+	_, _, err = big.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig, websocket.CloseNormalClosure), "message over limit should close, got %v", err)
+	big.Close()
+
+	err = service.DoClose(h)
+	assert.NoError(t, err, "close fail")
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "expect going away close frame, got %v", err)
+	assert.Equal(t, float64(0), h.Statistics()[StatWebSocketConns])
+	assert.Equal(t, float64(2), h.Statistics()[StatWebSocketOpened])
+}
+
+func TestHttpD_WebSocketFlushBeforeClose(t *testing.T) {
+	h := New(NewConfig("127.0.0.1:0"))
+
+	r := mux.NewRouter()
+	r.Handle("/ws", h.WebSocketHandler(NewWebSocketConfig(), func(conn *WSConn, r *http.Request) {
+		for i := 0; i < 32; i++ {
+			_ = conn.Send(websocket.TextMessage, []byte("msg"))
+		}
+	}))
+	h.SetHandler(r)
+
+	err := service.DoOpen(h, context.Background(), log)
+	assert.NoError(t, err, "open httpd fail")
+	defer service.DoClose(h)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+h.Addr().String()+"/ws", nil)
+	assert.NoError(t, err, "dial fail")
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n := 0
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		n++
+	}
+	assert.Equal(t, 32, n, "all queued messages should be written before close")
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "expect normal close frame, got %v", err)
+}
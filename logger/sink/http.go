@@ -2,13 +2,9 @@ package sink
 
 import (
 	"bytes"
-	"context"
-	"errors"
 	"fmt"
 	"github.com/donkeywon/gtil/httpc"
-	"github.com/donkeywon/gtil/util"
 	"go.uber.org/zap"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 )
@@ -25,8 +21,18 @@ func init() {
 }
 
 func httpSink(url *url.URL) (zap.Sink, error) {
+	return NewHttp(url)
+}
+
+// NewHttp creates a sink posting each write to url, opts customize its httpc.HttpC.
+func NewHttp(url *url.URL, opts ...httpc.Option) (*Http, error) {
+	client, err := httpc.New(httpc.NewConfig(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Http{
-		httpc: httpc.New(httpc.NewConfig(), context.Background()),
+		httpc: client,
 		url:   url,
 	}, nil
 }
@@ -37,26 +43,13 @@ type Http struct {
 }
 
 func (h *Http) Write(p []byte) (n int, err error) {
-	req, err := http.NewRequest("POST", h.url.String(), bytes.NewReader(p))
+	_, err = h.httpc.NewRequest(http.MethodPost, h.url.String()).
+		Body(bytes.NewReader(p), "").
+		Bytes()
 	if err != nil {
 		return 0, err
 	}
 
-	resp, err := h.httpc.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
-	}
-
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return 0, errors.New(util.Bytes2String(respBody))
-	}
-
 	return len(p), nil
 }
 
@@ -1,19 +1,32 @@
 package sink
 
 import (
-	"github.com/donkeywon/gtil/logger"
+	"github.com/donkeywon/gtil/httpc"
+	"github.com/donkeywon/gtil/httpc/httpctest"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
 )
 
 func TestHttp_Write(t *testing.T) {
-	lc := logger.DefaultConfig()
-	lc.Encoding = "json"
-	lc.OutputPaths = append(lc.OutputPaths, "http://httpbin.org/post")
+	mock := httpctest.NewMock()
+	rule := mock.On(http.MethodPost, "/post").
+		WithBodyFunc(func(body []byte) bool {
+			return strings.Contains(string(body), `"msg":"WTF message"`) && strings.Contains(string(body), `"tag":"value"`)
+		}).
+		Respond(http.StatusNoContent, "")
 
-	l, err := logger.FromConfig(lc)
-	assert.NoError(t, err, "init logger fail")
+	u, _ := url.Parse("http://log.local/post")
+	s, err := NewHttp(u, httpc.WithTransport(mock))
+	assert.NoError(t, err, "new http sink fail")
+	defer s.Close()
 
+	l := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), s, zapcore.InfoLevel))
 	l.Info("WTF message", zap.String("tag", "value"))
+
+	mock.AssertCalls(t, rule, 1)
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"sync"
 )
 
 type Service interface {
@@ -97,6 +98,7 @@ func DoShutdown(self Service) error {
 type BaseService struct {
 	*zap.Logger
 	ctx         context.Context
+	errMu       sync.Mutex
 	err         error
 	closed      chan struct{}
 	children    map[string]Service
@@ -107,6 +109,7 @@ type BaseService struct {
 
 func NewBase() *BaseService {
 	return &BaseService{
+		Logger:      zap.NewNop(),
 		closed:      make(chan struct{}),
 		children:    make(map[string]Service),
 		childrenArr: make([]Service, 0, 1),
@@ -158,7 +161,9 @@ func (bs *BaseService) openChildren() error {
 }
 
 func (bs *BaseService) closeChildren() {
-	bs.childCancel()
+	if bs.childCancel != nil {
+		bs.childCancel()
+	}
 }
 
 func (bs *BaseService) waitChildrenClose() {
@@ -190,6 +195,8 @@ func (bs *BaseService) AppendService(name string, svc Service) {
 }
 
 func (bs *BaseService) AppendError(err ...error) {
+	bs.errMu.Lock()
+	defer bs.errMu.Unlock()
 	bs.err = multierr.Append(bs.err, multierr.Combine(err...))
 }
 
@@ -198,5 +205,7 @@ func (bs *BaseService) Statistics() map[string]float64 {
 }
 
 func (bs *BaseService) LastError() error {
+	bs.errMu.Lock()
+	defer bs.errMu.Unlock()
 	return bs.err
 }